@@ -2,9 +2,14 @@ package main
 
 import (
 	"fmt"
+	"image"
+	_ "image/gif"  // décodage des images de palette
+	_ "image/jpeg" // décodage des images de palette
+	_ "image/png"  // décodage des images de palette
 	"io"
 	"net"
 	"os"
+	"strings"
 )
 
 func main() {
@@ -39,20 +44,39 @@ func main() {
 
 	// 4) Demander à l'utilisateur quel traitement il veut
 	fmt.Println("=== Choix du traitement ===")
-	fmt.Println("1. Noir et blanc (BW)")
-	fmt.Println("2. Downscale (facteur 4)")
-	fmt.Println("3. Remap vers carosse_500x500.jpg")
-	fmt.Print("Votre choix (1-3): ")
+	fmt.Println("1. Noir et blanc (BW, méthode et désaturation au choix)")
+	fmt.Println("2. Downscale (facteur et espace de calcul au choix)")
+	fmt.Println("3. Remap vers carosse_500x500.jpg (méthode, niveaux, distance, affinage et graine au choix)")
+	fmt.Println("4. Resize (taille et noyau au choix)")
+	fmt.Println("5. Upscale (facteur et méthode au choix)")
+	fmt.Println("6. Noir et blanc 1 bit (seuillage ou tramage)")
+	fmt.Println("7. Réduction à une palette de couleurs (quantification)")
+	fmt.Println("8. Projection sur une palette imposée")
+	fmt.Println("9. Filtre (flou, netteté, estampage, noyau personnalisé)")
+	fmt.Println("10. Contours (Sobel, Prewitt, laplacien de gaussienne, Canny)")
+	fmt.Println("11. Débruitage (médian, bilatéral)")
+	fmt.Println("12. Réglages (luminosité, contraste, gamma, exposition, niveaux)")
+	fmt.Println("13. Égalisation d'histogramme (globale, CLAHE)")
+	fmt.Println("14. Transfert des couleurs de l'image cible (histogrammes, Reinhard)")
+	fmt.Print("Votre choix (1-14): ")
 
 	var choice int
-	_, err := fmt.Scanf("%d", &choice)
-	if err != nil || choice < 1 || choice > 3 {
+	_, err := fmt.Scanln(&choice)
+	if err != nil || choice < 1 || choice > 14 {
 		fmt.Println("Choix invalide!")
 		return
 	}
 
-	// Envoyer le choix (1 byte)
+	// Demander les paramètres du traitement choisi
+	params, err := askParams(choice)
+	if err != nil {
+		fmt.Println("Paramètres invalides:", err)
+		return
+	}
+
+	// Envoyer le choix (1 byte) puis la ligne de paramètres
 	conn.Write([]byte{byte(choice)})
+	conn.Write([]byte(params + "\n"))
 
 	// Envoyer l'image
 	in, err := os.Open(imagePath)
@@ -65,10 +89,230 @@ func main() {
 	io.Copy(conn, in)                // envoie l'image
 	conn.(*net.TCPConn).CloseWrite() // signale la fin de l'envoi
 
-	out, _ := os.Create("output/out.jpg")
+	// Le serveur répond en JPEG, sauf si un autre format a été demandé
+	outName := "out.jpg"
+	for _, field := range strings.Fields(params) {
+		if format, ok := strings.CutPrefix(field, "format="); ok && format != "jpeg" {
+			outName = "out." + format
+		}
+	}
+	out, _ := os.Create("output/" + outName)
 	defer out.Close()
 
 	io.Copy(out, conn) // reçoit l'image renvoyée
 
-	fmt.Println("Traitement terminé! Résultat sauvegardé dans out.jpg")
+	fmt.Println("Traitement terminé! Résultat sauvegardé dans " + outName)
+}
+
+// askParams demande les paramètres propres au traitement choisi et retourne la
+// ligne "cle=valeur ..." attendue par le serveur (vide si aucun paramètre).
+func askParams(choice int) (string, error) {
+	var params []string
+	switch choice {
+	case 1: // BW
+		mode := ask("Méthode (bt601, bt709, linear, average, lightness, red, green, blue, custom) [bt601]: ")
+		params = appendParam(params, "mode", mode)
+		if mode == "custom" {
+			params = appendParam(params, "weights", ask("Poids r,g,b (ex: 0.5,0.3,0.2): "))
+		}
+		params = appendParam(params, "amount", strings.TrimSuffix(ask("Désaturation en % [100]: "), "%"))
+		params = appendParam(params, "space", ask("Calcul (srgb, linear = en lumière linéaire) [srgb]: "))
+	case 2: // Downscale
+		params = appendParam(params, "factor", ask("Facteur [4]: "))
+		params = appendParam(params, "space", ask("Moyenne des blocs (srgb, linear = en lumière linéaire) [srgb]: "))
+	case 3: // Remap
+		method := ask("Méthode (bins, sliced = transport optimal, plus lent) [bins]: ")
+		params = appendParam(params, "method", method)
+		if method == "sliced" {
+			params = appendParam(params, "iterations", ask("Itérations (0 à 200) [20]: "))
+		} else {
+			params = appendParam(params, "levels", ask("Niveaux par canal (2 à 64) [16]: "))
+			params = appendParam(params, "distance", ask("Distance du repli sur un bin épuisé (rgb, redmean, cie76, ciede2000) [rgb]: "))
+		}
+		refine := ask("Passes d'affinage par échanges (0 à 100) [0]: ")
+		params = appendParam(params, "refine", refine)
+		if refine != "" && refine != "0" {
+			params = appendParam(params, "refine_sigma", ask("Flou de la cible de l'affinage (0 = nette) [0]: "))
+		}
+		params = appendParam(params, "seed", ask("Graine (même graine = même image) [1]: "))
+	case 4: // Resize
+		size := ask("Taille cible (ex: 800x600, 50%, fit:800x600, w:800, h:600): ")
+		switch {
+		case strings.HasSuffix(size, "%"):
+			params = append(params, "scale="+strings.TrimSuffix(size, "%"))
+		case strings.HasPrefix(size, "fit:"):
+			params = append(params, "fit="+strings.TrimPrefix(size, "fit:"))
+		case strings.HasPrefix(size, "w:"):
+			params = append(params, "width="+strings.TrimPrefix(size, "w:"))
+		case strings.HasPrefix(size, "h:"):
+			params = append(params, "height="+strings.TrimPrefix(size, "h:"))
+		case strings.Contains(size, "x"):
+			params = append(params, "size="+size)
+		default:
+			return "", fmt.Errorf("taille %q non reconnue", size)
+		}
+		params = appendParam(params, "kernel", ask("Noyau (nearest, box, bilinear, bicubic, lanczos) [lanczos]: "))
+	case 5: // Upscale
+		params = appendParam(params, "factor", ask("Facteur d'agrandissement [2]: "))
+		params = appendParam(params, "method", ask("Méthode (nearest, bilinear, bicubic, scale2x, scale3x) [nearest]: "))
+	case 6: // Noir et blanc 1 bit
+		method := ask("Méthode (fixed, otsu, adaptive, floyd-steinberg, atkinson, bayer, bluenoise) [fixed]: ")
+		params = appendParam(params, "method", method)
+		switch method {
+		case "", "fixed":
+			params = appendParam(params, "threshold", ask("Seuil en % [50]: "))
+		case "adaptive":
+			params = appendParam(params, "radius", ask("Rayon du voisinage [15]: "))
+			params = appendParam(params, "offset", ask("Marge sous la moyenne locale en % [2]: "))
+		case "bayer":
+			params = appendParam(params, "bayer", ask("Taille de la matrice (2, 4, 8) [4]: "))
+		}
+		params = append(params, "format="+askFormat("png = PNG 1 bit"))
+	case 7: // Quantification
+		method := ask("Méthode (uniform, mediancut, octree, kmeans) [mediancut]: ")
+		params = appendParam(params, "method", method)
+		if method == "uniform" {
+			params = appendParam(params, "levels", ask("Niveaux par canal (2 à 6) [4]: "))
+		} else {
+			params = appendParam(params, "colors", ask("Nombre de couleurs (2 à 256) [16]: "))
+		}
+		params = appendParam(params, "dither", ask("Tramage (none, floyd-steinberg, atkinson) [none]: "))
+		params = append(params, "format="+askFormat("png/gif = image palettisée"))
+	case 8: // Palette imposée
+		palette := ask("Palette (gameboy, cga, pico8, target, #rrggbb,#rrggbb,... ou image de palette) [pico8]: ")
+		switch palette {
+		case "":
+			palette = "pico8"
+		case "target":
+			params = appendParam(params, "colors", ask("Nombre de couleurs extraites de la cible [16]: "))
+		default:
+			if _, err := os.Stat(palette); err == nil {
+				hex, err := paletteFromFile(palette)
+				if err != nil {
+					return "", err
+				}
+				palette = hex
+			}
+		}
+		params = append(params, "palette="+palette)
+		params = appendParam(params, "distance", ask("Distance (rgb, redmean, cie76, ciede2000) [rgb]: "))
+		params = appendParam(params, "dither", ask("Tramage (none, floyd-steinberg, atkinson) [none]: "))
+		params = append(params, "format="+askFormat("png/gif = image palettisée"))
+	case 9: // Filtre
+		name := ask("Filtre (box, gaussian, sharpen, unsharp, emboss, custom) [gaussian]: ")
+		params = appendParam(params, "filter", name)
+		switch name {
+		case "box":
+			params = appendParam(params, "radius", ask("Rayon [2]: "))
+		case "", "gaussian", "unsharp":
+			params = appendParam(params, "sigma", ask("Sigma en pixels [2]: "))
+		case "custom":
+			params = appendParam(params, "matrix", ask("Noyau (lignes séparées par ';', 15x15 au plus, ex: 1,2,1;2,4,2;1,2,1): "))
+		}
+		if name == "sharpen" || name == "unsharp" {
+			params = appendParam(params, "amount", ask("Intensité en % [100]: "))
+		}
+		params = appendParam(params, "edge", ask("Bords (clamp, wrap, mirror, zero) [clamp]: "))
+	case 10: // Contours
+		method := ask("Détecteur (sobel, prewitt, log, canny) [sobel]: ")
+		params = appendParam(params, "method", method)
+		switch method {
+		case "", "sobel", "prewitt":
+			params = appendParam(params, "output", ask("Sortie (magnitude, direction) [magnitude]: "))
+		case "log":
+			params = appendParam(params, "sigma", ask("Sigma du lissage [1.4]: "))
+			params = appendParam(params, "threshold", ask("Pente minimale en % [3]: "))
+		case "canny":
+			params = appendParam(params, "sigma", ask("Sigma du lissage [1.4]: "))
+			params = appendParam(params, "low", ask("Seuil bas en % [10]: "))
+			params = appendParam(params, "high", ask("Seuil haut en % [25]: "))
+		}
+		params = append(params, "format="+askFormat("png = sans perte"))
+	case 11: // Débruitage
+		method := ask("Méthode (median, bilateral) [median]: ")
+		params = appendParam(params, "method", method)
+		if method == "bilateral" {
+			params = appendParam(params, "sigma", ask("Sigma spatial en pixels [3]: "))
+			params = appendParam(params, "range", ask("Sigma de couleur en % [10]: "))
+		} else {
+			params = appendParam(params, "radius", ask("Rayon [2]: "))
+		}
+	case 12: // Réglages
+		params = appendParam(params, "brightness", ask("Luminosité (-100 à 100) [0]: "))
+		params = appendParam(params, "contrast", ask("Contraste (-100 à 100) [0]: "))
+		params = appendParam(params, "gamma", ask("Gamma [1]: "))
+		params = appendParam(params, "exposure", ask("Exposition en EV [0]: "))
+		params = appendParam(params, "levels", ask("Niveaux en % noirEntrée,blancEntrée,noirSortie,blancSortie [0,100,0,100]: "))
+		for _, c := range []string{"r", "g", "b"} {
+			params = appendParam(params, "levels_"+c, ask("Niveaux du canal "+strings.ToUpper(c)+" (vide = comme ci-dessus): "))
+		}
+	case 13: // Égalisation
+		method := ask("Méthode (global, clahe) [clahe]: ")
+		params = appendParam(params, "method", method)
+		if method != "global" {
+			params = appendParam(params, "tiles", ask("Grille de tuiles XxY [8x8]: "))
+			params = appendParam(params, "clip", ask("Limite de contraste (1 à 256) [2]: "))
+		}
+	case 14: // Transfert de couleurs
+		params = appendParam(params, "method", ask("Méthode (histogram, reinhard) [histogram]: "))
+	}
+	return strings.Join(params, " "), nil
+}
+
+// ask affiche prompt et retourne la réponse (vide si l'utilisateur valide directement)
+func ask(prompt string) string {
+	fmt.Print(prompt)
+	var answer string
+	fmt.Scanln(&answer)
+	return answer
+}
+
+// askFormat demande le format de l'image renvoyée (png par défaut)
+func askFormat(hint string) string {
+	format := ask("Format (" + hint + ", jpeg) [png]: ")
+	if format == "" {
+		format = "png"
+	}
+	return format
+}
+
+// appendParam ajoute key=value à params, sauf si value est vide (valeur par défaut du serveur)
+func appendParam(params []string, key, value string) []string {
+	if value == "" {
+		return params
+	}
+	return append(params, key+"="+value)
+}
+
+// paletteFromFile lit une image de palette et retourne ses couleurs distinctes
+// sous la forme "rrggbb,rrggbb,..." (256 couleurs au plus)
+func paletteFromFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return "", fmt.Errorf("image de palette illisible: %v", err)
+	}
+
+	seen := map[string]bool{}
+	var colors []string
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := img.At(x, y).RGBA()
+			hex := fmt.Sprintf("%02x%02x%02x", r>>8, g>>8, bl>>8)
+			if seen[hex] {
+				continue
+			}
+			if len(colors) == 256 {
+				return "", fmt.Errorf("l'image de palette a plus de 256 couleurs")
+			}
+			seen[hex] = true
+			colors = append(colors, hex)
+		}
+	}
+	return strings.Join(colors, ","), nil
 }
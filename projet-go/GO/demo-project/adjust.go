@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// levelsRange décrit l'outil "niveaux" sur un canal, en % de la dynamique :
+// [InBlack, InWhite] est étiré sur [OutBlack, OutWhite].
+type levelsRange struct {
+	InBlack, InWhite   float64
+	OutBlack, OutWhite float64
+}
+
+// identityLevels ne modifie pas le canal
+var identityLevels = levelsRange{InBlack: 0, InWhite: 100, OutBlack: 0, OutWhite: 100}
+
+// adjustOptions décrit un réglage tonal. Les réglages s'appliquent dans
+// l'ordre : niveaux (par canal), exposition, luminosité, contraste, gamma.
+type adjustOptions struct {
+	// Levels sont les niveaux des canaux R, G et B.
+	Levels [3]levelsRange
+	// Exposure est l'exposition en diaphragmes (EV) : +1 double la lumière
+	// (calculée en lumière linéaire, comme un appareil photo).
+	Exposure float64
+	// Brightness (-100 à 100) est ajouté à chaque canal, en % de la dynamique.
+	Brightness float64
+	// Contrast (-100 à 100) étire (> 0) ou resserre (< 0) les valeurs autour du
+	// gris moyen ; -100 donne un gris uniforme, 100 double les écarts.
+	Contrast float64
+	// Gamma (> 0) : valeur^(1/Gamma) ; > 1 éclaircit les tons moyens.
+	Gamma float64
+}
+
+// defaultAdjustOptions ne modifie pas l'image.
+var defaultAdjustOptions = adjustOptions{
+	Levels: [3]levelsRange{identityLevels, identityLevels, identityLevels},
+	Gamma:  1,
+}
+
+// checkAdjust retourne une erreur si les options sont invalides
+func checkAdjust(opts adjustOptions) error {
+	// NaN passe toutes les comparaisons : il est refusé explicitement
+	outside := func(v, lo, hi float64) bool {
+		return math.IsNaN(v) || v < lo || v > hi
+	}
+	for c, l := range opts.Levels {
+		for _, v := range []float64{l.InBlack, l.InWhite, l.OutBlack, l.OutWhite} {
+			if outside(v, 0, 100) {
+				return fmt.Errorf("niveaux du canal %d : %v hors de [0, 100]", c, v)
+			}
+		}
+		if l.InBlack >= l.InWhite {
+			return fmt.Errorf("niveaux du canal %d : point noir %v >= point blanc %v", c, l.InBlack, l.InWhite)
+		}
+	}
+	if outside(opts.Exposure, -10, 10) {
+		return fmt.Errorf("exposition %v hors de [-10, 10]", opts.Exposure)
+	}
+	if outside(opts.Brightness, -100, 100) {
+		return fmt.Errorf("luminosité %v hors de [-100, 100]", opts.Brightness)
+	}
+	if outside(opts.Contrast, -100, 100) {
+		return fmt.Errorf("contraste %v hors de [-100, 100]", opts.Contrast)
+	}
+	if outside(opts.Gamma, 0, 10) || opts.Gamma == 0 {
+		return fmt.Errorf("gamma %v hors de ]0, 10]", opts.Gamma)
+	}
+	return nil
+}
+
+// adjustCurve retourne le réglage du canal c sous forme de fonction sur [0, 1]
+func adjustCurve(opts adjustOptions, c int) func(v float64) float64 {
+	l := opts.Levels[c]
+	inBlack, inWhite := l.InBlack/100, l.InWhite/100
+	outBlack, outWhite := l.OutBlack/100, l.OutWhite/100
+	gain := math.Exp2(opts.Exposure)
+	brightness := opts.Brightness / 100
+	contrast := (100 + opts.Contrast) / 100
+	clamp := func(v float64) float64 { return min(max(v, 0), 1) }
+
+	return func(v float64) float64 {
+		v = clamp((v - inBlack) / (inWhite - inBlack))
+		v = outBlack + v*(outWhite-outBlack)
+		if gain != 1 {
+			v = encodeSRGB(clamp(decodeSRGB(v) * gain))
+		}
+		v = clamp(v + brightness)
+		v = clamp((v-0.5)*contrast + 0.5)
+		return math.Pow(v, 1/opts.Gamma)
+	}
+}
+
+// adjustTables retourne, pour chaque canal, la table des 65536 valeurs
+// réglées : appliquer le réglage revient alors à une lecture par canal.
+func adjustTables(opts adjustOptions, exec *Executor) [3][]uint16 {
+	var luts [3][]uint16
+	for c := range luts {
+		if c > 0 && opts.Levels[c] == opts.Levels[c-1] {
+			luts[c] = luts[c-1] // même courbe que le canal précédent
+			continue
+		}
+		curve := adjustCurve(opts, c)
+		lut := make([]uint16, 65536)
+		runRows(exec, len(lut), func(start, end int) {
+			for i := start; i < end; i++ {
+				lut[i] = uint16(math.Round(curve(float64(i)/65535) * 65535))
+			}
+		})
+		luts[c] = lut
+	}
+	return luts
+}
+
+// adjustPixels applique le réglage tonal (séquentiel, in-place). Retourne
+// nil si les options sont invalides.
+func adjustPixels(buf *PixelBuffer, opts adjustOptions) *PixelBuffer {
+	return adjust(buf, opts, nil)
+}
+
+// adjust est l'implémentation commune : exec nil = séquentiel, sinon tables
+// et pixels sont répartis par bandes
+func adjust(buf *PixelBuffer, opts adjustOptions, exec *Executor) *PixelBuffer {
+	if checkAdjust(opts) != nil {
+		return nil
+	}
+	luts := adjustTables(opts, exec)
+	lutR, lutG, lutB := luts[0], luts[1], luts[2]
+	runRows(exec, buf.Height, func(start, end int) {
+		for y := start; y < end; y++ {
+			row := buf.row(y)
+			for x, p := range row {
+				row[x] = Pixel{R: lutR[p.R], G: lutG[p.G], B: lutB[p.B]}
+			}
+		}
+	})
+	return buf
+}
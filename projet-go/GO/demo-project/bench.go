@@ -1,8 +1,15 @@
 package main
 
 import (
+	"cmp"
 	"fmt"
 	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"math/rand"
+	"slices"
+	"sync"
 	"time"
 )
 
@@ -35,16 +42,16 @@ func CompareExtractPixels(img image.Image) {
 }
 
 // CompareBlackWhite compare les versions séquentielle et parallèle de blackWhite
-func CompareBlackWhite(rgbMatrix [][]Pixel, width, height int) {
+func CompareBlackWhite(buf *PixelBuffer) {
 	fmt.Println("=== TEST blackWhite (SÉQUENTIEL) ===")
 	start1 := time.Now()
-	rgbMatrix1 := blackWhite(rgbMatrix, width, height)
+	rgbMatrix1 := blackWhite(buf)
 	duration1 := time.Since(start1)
 	fmt.Printf("Temps : %v\n\n", duration1)
 
 	fmt.Println("=== TEST blackWhiteParallel (PARALLÈLE) ===")
 	start2 := time.Now()
-	rgbMatrix2 := blackWhiteParallel(rgbMatrix, width, height)
+	rgbMatrix2 := blackWhiteParallel(buf)
 	duration2 := time.Since(start2)
 	fmt.Printf("Temps : %v\n\n", duration2)
 
@@ -59,16 +66,44 @@ func CompareBlackWhite(rgbMatrix [][]Pixel, width, height int) {
 	_ = rgbMatrix2
 }
 
-func CompareDownscalePixels(rgbMatrix [][]Pixel, width, height int) {
+// CompareEdges compare edgePixels et edgePixelsParallel pour chaque détecteur
+// de contours, construits sur le gris de blackWhite (résultats attendus identiques).
+func CompareEdges(buf *PixelBuffer) {
+	fmt.Println("=== TEST edgePixels (SÉQUENTIEL vs PARALLÈLE) ===")
+	for _, method := range edgeMethods {
+		for _, output := range []string{"magnitude", "direction"} {
+			if output == "direction" && method != "sobel" && method != "prewitt" {
+				continue
+			}
+			opts := defaultEdgeOptions
+			opts.Method = method
+			opts.Output = output
+
+			start1 := time.Now()
+			out1 := edgePixels(buf, opts)
+			duration1 := time.Since(start1)
+
+			start2 := time.Now()
+			out2 := edgePixelsParallel(buf, opts)
+			duration2 := time.Since(start2)
+
+			fmt.Printf("%-8s %-10s séquentiel %-14v parallèle %-14v speedup %.2fx identique : %v\n",
+				method, output, duration1, duration2, float64(duration1)/float64(duration2), out1.equal(out2))
+		}
+	}
+	fmt.Println()
+}
+
+func CompareDownscalePixels(buf *PixelBuffer) {
 	fmt.Println("=== TEST downscalePixels (SÉQUENTIEL) ===")
 	start1 := time.Now()
-	rgbMatrix1 := downscalePixels(rgbMatrix, width, height, 2)
+	rgbMatrix1 := downscalePixels(buf, 2)
 	duration1 := time.Since(start1)
 	fmt.Printf("Temps : %v\n\n", duration1)
 
 	fmt.Println("=== TEST downscalePixelsParallel (PARALLÈLE) ===")
 	start2 := time.Now()
-	rgbMatrix2 := downscalePixelsParallel(rgbMatrix, width, height, 2)
+	rgbMatrix2 := downscalePixelsParallel(buf, 2)
 	duration2 := time.Since(start2)
 	fmt.Printf("Temps : %v\n\n", duration2)
 
@@ -83,16 +118,52 @@ func CompareDownscalePixels(rgbMatrix [][]Pixel, width, height int) {
 	_ = rgbMatrix2
 }
 
-func CompareRemapPixels(sourceMatrix [][]Pixel, destinationMatrix [][]Pixel, levels int) {
+func CompareRemapPixels(sourceMatrix, destinationMatrix *PixelBuffer, opts remapOptions) {
 	fmt.Println("=== TEST remapPixels (SÉQUENTIEL) ===")
 	start1 := time.Now()
-	rgbMatrix1 := remapPixels(sourceMatrix, destinationMatrix, levels)
+	rgbMatrix1 := remapPixels(sourceMatrix, destinationMatrix, opts)
 	duration1 := time.Since(start1)
 	fmt.Printf("Temps : %v\n\n", duration1)
 
 	fmt.Println("=== TEST remapPixels (PARALLÈLE) ===")
 	start2 := time.Now()
-	rgbMatrix2 := remapPixelsParallel(sourceMatrix, destinationMatrix, levels)
+	rgbMatrix2 := remapPixelsParallel(sourceMatrix, destinationMatrix, opts)
+	duration2 := time.Since(start2)
+	fmt.Printf("Temps : %v\n\n", duration2)
+
+	fmt.Println("=== COMPARAISON ===")
+	speedup := float64(duration1) / float64(duration2)
+	savings := (1 - float64(duration2)/float64(duration1)) * 100
+	fmt.Printf("Speedup : %.2fx\n", speedup)
+	fmt.Printf("Gain de temps : %.2f%%\n", savings)
+	fmt.Printf("Différence : %v\n", duration1-duration2)
+	fmt.Printf("Résultats identiques (graine %d) : %v\n\n", opts.Seed, rgbMatrix1.equal(rgbMatrix2))
+}
+
+// CompareMatrixBuffer compare l'ancienne représentation [][]Pixel (une allocation
+// par ligne) avec PixelBuffer (un seul tableau contigu) sur extraction + noir et
+// blanc + copie + pixelisation.
+func CompareMatrixBuffer(img image.Image, runs int) {
+	bounds := img.Bounds()
+	width, height := bounds.Max.X, bounds.Max.Y
+
+	fmt.Println("=== TEST [][]Pixel (MATRICE) ===")
+	start1 := time.Now()
+	for i := 0; i < runs; i++ {
+		rgbMatrix := extractMatrix(img, width, height)
+		gray := blackWhiteMatrix(copyMatrix(rgbMatrix), width, height)
+		_ = downscaleMatrix(gray, width, height, 4)
+	}
+	duration1 := time.Since(start1)
+	fmt.Printf("Temps : %v\n\n", duration1)
+
+	fmt.Println("=== TEST PixelBuffer (CONTIGU) ===")
+	start2 := time.Now()
+	for i := 0; i < runs; i++ {
+		buf := extractPixels(img, width, height)
+		gray := blackWhite(buf.clone())
+		_ = downscalePixels(gray, 4)
+	}
 	duration2 := time.Since(start2)
 	fmt.Printf("Temps : %v\n\n", duration2)
 
@@ -102,7 +173,1170 @@ func CompareRemapPixels(sourceMatrix [][]Pixel, destinationMatrix [][]Pixel, lev
 	fmt.Printf("Speedup : %.2fx\n", speedup)
 	fmt.Printf("Gain de temps : %.2f%%\n", savings)
 	fmt.Printf("Différence : %v\n\n", duration1-duration2)
+}
 
-	_ = rgbMatrix1
-	_ = rgbMatrix2
+// extractMatrix, blackWhiteMatrix et downscaleMatrix sont les anciennes versions
+// sur [][]Pixel, conservées uniquement comme référence pour CompareMatrixBuffer.
+func extractMatrix(m image.Image, width, height int) [][]Pixel {
+	rgbMatrix := make([][]Pixel, height)
+	for y := 0; y < height; y++ {
+		rgbMatrix[y] = make([]Pixel, width)
+		for x := 0; x < width; x++ {
+			r, g, b, _ := m.At(x, y).RGBA()
+			rgbMatrix[y][x] = Pixel{R: uint16(r), G: uint16(g), B: uint16(b)}
+		}
+	}
+	return rgbMatrix
+}
+
+func blackWhiteMatrix(rgbMatrix [][]Pixel, width, height int) [][]Pixel {
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			p := rgbMatrix[y][x]
+			gray := uint16(0.299*float64(p.R) + 0.587*float64(p.G) + 0.114*float64(p.B))
+			rgbMatrix[y][x] = Pixel{R: gray, G: gray, B: gray}
+		}
+	}
+	return rgbMatrix
+}
+
+func downscaleMatrix(rgbMatrix [][]Pixel, width, height, factor int) [][]Pixel {
+	result := make([][]Pixel, height)
+	for y := 0; y < height; y++ {
+		result[y] = make([]Pixel, width)
+	}
+	for by := 0; by < height; by += factor {
+		for bx := 0; bx < width; bx += factor {
+			var sumR, sumG, sumB uint64
+			count := 0
+			maxY := min(by+factor, height)
+			maxX := min(bx+factor, width)
+			for y := by; y < maxY; y++ {
+				for x := bx; x < maxX; x++ {
+					p := rgbMatrix[y][x]
+					sumR += uint64(p.R)
+					sumG += uint64(p.G)
+					sumB += uint64(p.B)
+					count++
+				}
+			}
+			avg := Pixel{
+				R: uint16(sumR / uint64(count)),
+				G: uint16(sumG / uint64(count)),
+				B: uint16(sumB / uint64(count)),
+			}
+			for y := by; y < maxY; y++ {
+				for x := bx; x < maxX; x++ {
+					result[y][x] = avg
+				}
+			}
+		}
+	}
+	return result
+}
+
+// CheckExtractFastPath vérifie que les chemins rapides de rowExtractor donnent
+// exactement les mêmes pixels que le chemin générique m.At(x, y).RGBA(), pour
+// l'image fournie et pour des images aléatoires de chaque type concret
+// (alpha partiel, toutes les sous-échantillonnages YCbCr, tailles impaires).
+func CheckExtractFastPath(img image.Image) bool {
+	fmt.Println("=== VÉRIFICATION extractPixels (CHEMINS RAPIDES) ===")
+	rng := rand.New(rand.NewSource(1))
+	fill := func(b []byte) []byte {
+		rng.Read(b)
+		return b
+	}
+	r := image.Rect(0, 0, 37, 23)
+
+	cases := []struct {
+		name string
+		img  image.Image
+	}{{"image source", img}}
+	for _, ratio := range []image.YCbCrSubsampleRatio{
+		image.YCbCrSubsampleRatio444, image.YCbCrSubsampleRatio422, image.YCbCrSubsampleRatio420,
+		image.YCbCrSubsampleRatio440, image.YCbCrSubsampleRatio411, image.YCbCrSubsampleRatio410,
+	} {
+		m := image.NewYCbCr(r, ratio)
+		fill(m.Y)
+		fill(m.Cb)
+		fill(m.Cr)
+		cases = append(cases, struct {
+			name string
+			img  image.Image
+		}{ratio.String(), m})
+	}
+	rgba := image.NewRGBA(r)
+	fill(rgba.Pix)
+	rgba64 := image.NewRGBA64(r)
+	fill(rgba64.Pix)
+	nrgba := image.NewNRGBA(r)
+	fill(nrgba.Pix)
+	nrgba64 := image.NewNRGBA64(r)
+	fill(nrgba64.Pix)
+	gray := image.NewGray(r)
+	fill(gray.Pix)
+	gray16 := image.NewGray16(r)
+	fill(gray16.Pix)
+	palette := make(color.Palette, 200)
+	for i := range palette {
+		palette[i] = color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))}
+	}
+	paletted := image.NewPaletted(r, palette)
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(rng.Intn(len(palette)))
+	}
+	cases = append(cases, []struct {
+		name string
+		img  image.Image
+	}{
+		{"RGBA", rgba}, {"RGBA64", rgba64}, {"NRGBA", nrgba}, {"NRGBA64", nrgba64},
+		{"Gray", gray}, {"Gray16", gray16}, {"Paletted", paletted},
+	}...)
+
+	ok := true
+	for _, c := range cases {
+		bounds := c.img.Bounds()
+		width, height := bounds.Max.X, bounds.Max.Y
+
+		start1 := time.Now()
+		generic := newPixelBuffer(width, height)
+		for y := 0; y < height; y++ {
+			extractRowGeneric(c.img, y, generic.row(y))
+		}
+		duration1 := time.Since(start1)
+
+		start2 := time.Now()
+		fast := extractPixels(c.img, width, height)
+		duration2 := time.Since(start2)
+
+		same := fast.equal(generic) && extractPixelsParallel(c.img, width, height).equal(generic)
+		status := "OK"
+		if !same {
+			status = "DIFFÉRENT"
+			ok = false
+		}
+		fmt.Printf("%-24s %-9s générique %v / rapide %v\n", c.name, status, duration1, duration2)
+	}
+	fmt.Println()
+	return ok
+}
+
+// CheckPixelBufferImage vérifie que PixelBuffer se comporte comme une image
+// standard : draw.Draw vers un buffer équivaut à extractPixels, et blackWhiteImage
+// sur une *image.RGBA équivaut à blackWhite sur le buffer correspondant.
+func CheckPixelBufferImage(img image.Image) bool {
+	fmt.Println("=== VÉRIFICATION PixelBuffer (image.Image / draw.Image) ===")
+	bounds := img.Bounds()
+	width, height := bounds.Max.X, bounds.Max.Y
+	ref := extractPixels(img, width, height)
+
+	drawn := newPixelBuffer(width, height)
+	draw.Draw(drawn, drawn.Bounds(), img, image.Point{}, draw.Src)
+	okDraw := drawn.equal(ref)
+	fmt.Printf("draw.Draw -> PixelBuffer : %v\n", okDraw)
+
+	rgba := image.NewRGBA(bounds)
+	draw.Draw(rgba, bounds, ref, image.Point{}, draw.Src)
+	expected := extractPixels(pixelsToImage(blackWhite(extractPixels(rgba, width, height))), width, height)
+	blackWhiteImage(rgba)
+	okGray := extractPixels(rgba, width, height).equal(expected)
+	fmt.Printf("blackWhiteImage(*image.RGBA) : %v\n\n", okGray)
+
+	return okDraw && okGray
+}
+
+// CompareTileScheduler compare le découpage statique par bandes (Rows, Tiles)
+// et la file de tuiles partagée (DynamicTiles) sur une charge inégale : chaque
+// pixel source est ramené au bin non vide de la cible le plus proche, ce qui
+// coûte O(1) quand sa couleur existe dans la cible et un parcours de tous les
+// bins sinon (scanNearestBin, l'ancien repli du remap).
+func CompareTileScheduler(src, target *PixelBuffer, levels int) {
+	supply := newBinSupply(target, levels, "rgb")
+	exec := Executor{}
+	width, height := src.Width, src.Height
+
+	reference := newPixelBuffer(width, height)
+	snapToTargetBins(src, reference, supply, levels, src.Bounds())
+
+	run := func(name string, schedule func(dst *PixelBuffer)) {
+		dst := newPixelBuffer(width, height)
+		start := time.Now()
+		schedule(dst)
+		duration := time.Since(start)
+		fmt.Printf("%-26s Temps : %-14v identique : %v\n", name, duration, dst.equal(reference))
+	}
+
+	fmt.Println("=== TEST ordonnanceurs (charge inégale : repli binSupply.nearest) ===")
+	run("Rows (bandes statiques)", func(dst *PixelBuffer) {
+		exec.Rows(height, func(start, end int) {
+			snapToTargetBins(src, dst, supply, levels, image.Rect(0, start, width, end))
+		})
+	})
+	run("Tiles 64 (statiques)", func(dst *PixelBuffer) {
+		exec.Tiles(width, height, 64, 64, func(r image.Rectangle) {
+			snapToTargetBins(src, dst, supply, levels, r)
+		})
+	})
+	for _, tileSize := range []int{16, 32, 64, 128} {
+		run(fmt.Sprintf("DynamicTiles %d (file)", tileSize), func(dst *PixelBuffer) {
+			exec.DynamicTiles(width, height, tileSize, func(r image.Rectangle) {
+				snapToTargetBins(src, dst, supply, levels, r)
+			})
+		})
+	}
+	fmt.Println()
+}
+
+// snapToTargetBins écrit dans dst, sur le rectangle r, le centre du bin non
+// vide le plus proche de chaque pixel de src.
+func snapToTargetBins(src, dst *PixelBuffer, supply *binSupply, levels int, r image.Rectangle) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		in, out := src.row(y), dst.row(y)
+		for x := r.Min.X; x < r.Max.X; x++ {
+			bin := scanNearestBin(supply, quantizePixel(in[x], levels))
+			cR, cG, cB := binCenter(bin, levels)
+			out[x] = Pixel{R: uint16(cR), G: uint16(cG), B: uint16(cB)}
+		}
+	}
+}
+
+// scanNearestBin cherche le bin non vide le plus proche de bin en parcourant
+// tous les bins (le repli du remap avant le k-d tree de binSupply)
+func scanNearestBin(s *binSupply, bin int) int {
+	if len(s.bins[bin]) > 0 {
+		return bin
+	}
+	center := s.centers[bin]
+	bestIdx := -1
+	bestDist := math.Inf(1)
+	for idx, supply := range s.bins {
+		if len(supply) == 0 {
+			continue
+		}
+		if dist := s.dist(center, s.centers[idx]); dist < bestDist {
+			bestDist = dist
+			bestIdx = idx
+		}
+	}
+	return bestIdx
+}
+
+// CheckDownscaleParallel vérifie que downscalePixelsParallel donne exactement
+// le même résultat que downscalePixels, pour des tailles impaires, des facteurs
+// qui ne divisent pas la hauteur et des nombres de workers variés.
+func CheckDownscaleParallel() bool {
+	fmt.Println("=== VÉRIFICATION downscalePixels(Linear)Parallel == downscalePixels(Linear) ===")
+	rng := rand.New(rand.NewSource(2))
+	saved := parallelExec
+	defer func() { parallelExec = saved }()
+
+	ok := true
+	checked := 0
+	for _, size := range [][2]int{{1, 1}, {7, 5}, {13, 29}, {64, 63}, {101, 97}, {3, 250}} {
+		buf := newPixelBuffer(size[0], size[1])
+		for i := range buf.Pix {
+			buf.Pix[i] = Pixel{R: uint16(rng.Intn(65536)), G: uint16(rng.Intn(65536)), B: uint16(rng.Intn(65536))}
+		}
+		for _, factor := range []int{2, 3, 4, 5, 7, 8, 16} {
+			expected := downscalePixels(buf, factor)
+			expectedLinear := downscalePixelsLinear(buf, factor)
+			for _, workers := range []int{1, 2, 3, 5, 8, 17} {
+				parallelExec = Executor{Workers: workers}
+				checked++
+				if !downscalePixelsParallel(buf, factor).equal(expected) ||
+					!downscalePixelsLinearParallel(buf, factor).equal(expectedLinear) {
+					fmt.Printf("DIFFÉRENT : %dx%d, facteur %d, %d workers\n", size[0], size[1], factor, workers)
+					ok = false
+				}
+			}
+		}
+	}
+	fmt.Printf("%d cas vérifiés, identiques : %v\n\n", checked, ok)
+	return ok
+}
+
+// CompareResize compare resizePixels et resizePixelsParallel pour chaque noyau,
+// en réduction (moitié) puis en agrandissement (double).
+func CompareResize(buf *PixelBuffer) {
+	fmt.Println("=== TEST resizePixels (SÉQUENTIEL vs PARALLÈLE) ===")
+	for _, percent := range []float64{50, 200} {
+		dstW, dstH := scaleSize(buf.Width, buf.Height, percent)
+		for _, name := range []string{"nearest", "box", "bilinear", "bicubic", "lanczos"} {
+			k, _ := kernelByName(name)
+
+			start1 := time.Now()
+			out1 := resizePixels(buf, dstW, dstH, k)
+			duration1 := time.Since(start1)
+
+			start2 := time.Now()
+			out2 := resizePixelsParallel(buf, dstW, dstH, k)
+			duration2 := time.Since(start2)
+
+			fmt.Printf("%-10s %-9s séquentiel %-14v parallèle %-14v speedup %.2fx identique : %v\n",
+				fmt.Sprintf("%dx%d", dstW, dstH), name, duration1, duration2, float64(duration1)/float64(duration2), out1.equal(out2))
+		}
+	}
+	fmt.Println()
+}
+
+// CompareUpscale compare upscalePixels et upscalePixelsParallel pour chaque
+// méthode, sur une image pixelisée puis réduite (cas d'usage pixel art).
+func CompareUpscale(buf *PixelBuffer, factor int) {
+	fmt.Println("=== TEST upscalePixels (SÉQUENTIEL vs PARALLÈLE) ===")
+	box, _ := kernelByName("box")
+	small := resizePixels(buf, buf.Width/8, buf.Height/8, box)
+	for _, method := range upscaleMethods {
+		f := factor
+		if checkUpscale(method, f) != nil {
+			f = 3
+		}
+
+		start1 := time.Now()
+		out1 := upscalePixels(small, f, method)
+		duration1 := time.Since(start1)
+
+		start2 := time.Now()
+		out2 := upscalePixelsParallel(small, f, method)
+		duration2 := time.Since(start2)
+
+		fmt.Printf("x%d %-9s séquentiel %-14v parallèle %-14v speedup %.2fx identique : %v\n",
+			f, method, duration1, duration2, float64(duration1)/float64(duration2), out1.equal(out2))
+	}
+	fmt.Println()
+}
+
+// CompareGrayscale compare grayscalePixels et grayscalePixelsParallel pour
+// chaque mode, et vérifie que le mode bt601 complet redonne blackWhite.
+func CompareGrayscale(buf *PixelBuffer) {
+	fmt.Println("=== TEST grayscalePixels (SÉQUENTIEL vs PARALLÈLE) ===")
+	for _, mode := range grayModes {
+		opts := grayOptions{Mode: mode, Weights: [3]float64{1, 1, 2}, Amount: 100}
+
+		start1 := time.Now()
+		out1 := grayscalePixels(buf.clone(), opts)
+		duration1 := time.Since(start1)
+
+		start2 := time.Now()
+		out2 := grayscalePixelsParallel(buf.clone(), opts)
+		duration2 := time.Since(start2)
+
+		fmt.Printf("%-10s séquentiel %-14v parallèle %-14v speedup %.2fx identique : %v\n",
+			mode, duration1, duration2, float64(duration1)/float64(duration2), out1.equal(out2))
+	}
+	half := grayOptions{Mode: "linear", Amount: 50}
+	fmt.Printf("linear 50%% identique : %v\n", grayscalePixels(buf.clone(), half).equal(grayscalePixelsParallel(buf.clone(), half)))
+	fmt.Printf("bt601 100%% == blackWhite : %v\n\n", grayscalePixels(buf.clone(), defaultGrayOptions).equal(blackWhite(buf.clone())))
+}
+
+// CompareBinarize compare binarizePixels et binarizePixelsParallel pour chaque
+// méthode de seuillage et de tramage (résultats attendus identiques, diffusion
+// d'erreur comprise).
+func CompareBinarize(buf *PixelBuffer) {
+	fmt.Println("=== TEST binarizePixels (SÉQUENTIEL vs PARALLÈLE) ===")
+	blueNoiseThresholds() // génération du masque hors chronométrage
+	for _, method := range bilevelMethods {
+		opts := defaultBilevelOptions
+		opts.Method = method
+
+		start1 := time.Now()
+		out1 := binarizePixels(buf, opts)
+		duration1 := time.Since(start1)
+
+		start2 := time.Now()
+		out2 := binarizePixelsParallel(buf, opts)
+		duration2 := time.Since(start2)
+
+		fmt.Printf("%-16s séquentiel %-14v parallèle %-14v speedup %.2fx identique : %v\n",
+			method, duration1, duration2, float64(duration1)/float64(duration2), out1.equal(out2))
+	}
+	fmt.Println()
+}
+
+// CheckQuantizeStride vérifie que la quantification (et son tramage, qui
+// indexe l'image par lignes) donne le même résultat sur un buffer dont les
+// lignes sont espacées (Stride > Width) que sur sa copie compacte.
+func CheckQuantizeStride(buf *PixelBuffer) bool {
+	fmt.Println("=== VÉRIFICATION quantizeImage (STRIDE > WIDTH) ===")
+	padded := &PixelBuffer{Width: buf.Width, Height: buf.Height, Stride: buf.Width + 7}
+	padded.Pix = make([]Pixel, padded.Stride*buf.Height)
+	for y := 0; y < buf.Height; y++ {
+		row := padded.Pix[y*padded.Stride : (y+1)*padded.Stride]
+		copy(row, buf.row(y))
+		for x := buf.Width; x < len(row); x++ {
+			row[x] = Pixel{R: 65535} // marges : ne doivent pas être lues
+		}
+	}
+	ok := true
+	for _, dither := range []string{"none", "floyd-steinberg"} {
+		for _, method := range quantizeMethods {
+			opts := defaultQuantizeOptions
+			opts.Method = method
+			opts.Dither = dither
+			out1 := quantizeImage(buf, opts)
+			out2 := quantizeImageParallel(padded, opts)
+			same := slices.Equal(out1.Palette, out2.Palette) && string(out1.Pix) == string(out2.Pix)
+			fmt.Printf("%-10s %-16s identique : %v\n", method, dither, same)
+			ok = ok && same
+		}
+	}
+	fmt.Println()
+	return ok
+}
+
+// CompareQuantize compare quantizeImage et quantizeImageParallel pour chaque
+// méthode, avec et sans tramage (résultats attendus identiques), et affiche
+// l'écart moyen à l'image d'origine (RMS, sur 8 bits).
+func CompareQuantize(buf *PixelBuffer) {
+	fmt.Println("=== TEST quantizeImage (SÉQUENTIEL vs PARALLÈLE) ===")
+	for _, dither := range []string{"none", "floyd-steinberg"} {
+		for _, method := range quantizeMethods {
+			opts := defaultQuantizeOptions
+			opts.Method = method
+			opts.Dither = dither
+
+			start1 := time.Now()
+			out1 := quantizeImage(buf, opts)
+			duration1 := time.Since(start1)
+
+			start2 := time.Now()
+			out2 := quantizeImageParallel(buf, opts)
+			duration2 := time.Since(start2)
+
+			same := len(out1.Palette) == len(out2.Palette) && string(out1.Pix) == string(out2.Pix)
+			for i := range out1.Palette {
+				same = same && out1.Palette[i] == out2.Palette[i]
+			}
+			fmt.Printf("%-10s %-16s %3d couleurs, RMS %5.2f  séquentiel %-14v parallèle %-14v speedup %.2fx identique : %v\n",
+				method, dither, len(out1.Palette), quantizeError(buf, out1), duration1, duration2,
+				float64(duration1)/float64(duration2), same)
+		}
+	}
+	fmt.Println()
+}
+
+// quantizeError retourne l'écart RMS (sur 8 bits) entre buf et sa version palettisée
+func quantizeError(buf *PixelBuffer, img *image.Paletted) float64 {
+	var sum float64
+	for y := 0; y < buf.Height; y++ {
+		line := img.Pix[y*img.Stride:]
+		for x, p := range buf.row(y) {
+			r, g, b, _ := img.Palette[line[x]].RGBA()
+			sum += float64(sqDist(int(p.R), int(p.G), int(p.B), int(r), int(g), int(b)))
+		}
+	}
+	return math.Sqrt(sum/float64(3*len(buf.Pix))) / 257
+}
+
+// ComparePaletteMap compare paletteMapImage et paletteMapImageParallel sur une
+// palette nommée et sur la palette median cut de target (comme palette=target
+// côté serveur), pour chaque distance, avec et sans tramage.
+func ComparePaletteMap(buf, target *PixelBuffer) {
+	fmt.Println("=== TEST paletteMapImage (SÉQUENTIEL vs PARALLÈLE) ===")
+	pico8, _ := parsePalette("pico8")
+	fromTarget := medianCutPalette(colorHistogram(target, nil), defaultQuantizeOptions.Colors)
+	palettes := []struct {
+		name    string
+		palette []Pixel
+	}{
+		{"pico8", pico8},
+		{"cible", fromTarget},
+	}
+	for _, pal := range palettes {
+		for _, distance := range []string{"rgb", "redmean"} {
+			for _, dither := range []string{"none", "floyd-steinberg"} {
+				opts := paletteOptions{Palette: pal.palette, Distance: distance, Dither: dither}
+
+				start1 := time.Now()
+				out1 := paletteMapImage(buf, opts)
+				duration1 := time.Since(start1)
+
+				start2 := time.Now()
+				out2 := paletteMapImageParallel(buf, opts)
+				duration2 := time.Since(start2)
+
+				fmt.Printf("%-6s %-8s %-16s RMS %5.2f  séquentiel %-14v parallèle %-14v speedup %.2fx identique : %v\n",
+					pal.name, distance, dither, quantizeError(buf, out1), duration1, duration2,
+					float64(duration1)/float64(duration2), string(out1.Pix) == string(out2.Pix))
+			}
+		}
+	}
+	fmt.Println()
+}
+
+// CompareFilter compare filterPixels et filterPixelsParallel pour chaque
+// filtre prédéfini et chaque gestion de bord (résultats attendus identiques).
+func CompareFilter(buf *PixelBuffer) {
+	fmt.Println("=== TEST filterPixels (SÉQUENTIEL vs PARALLÈLE) ===")
+	for _, name := range filterNames {
+		for _, edge := range edgeModes {
+			opts := defaultFilterOptions
+			opts.Filter = name
+			opts.Edge = edge
+			if name == "custom" {
+				opts.Kernel, _ = parseKernel("1,2,1;2,4,2;1,2,1")
+			}
+
+			start1 := time.Now()
+			out1 := filterPixels(buf, opts)
+			duration1 := time.Since(start1)
+
+			start2 := time.Now()
+			out2 := filterPixelsParallel(buf, opts)
+			duration2 := time.Since(start2)
+
+			fmt.Printf("%-8s %-6s séquentiel %-14v parallèle %-14v speedup %.2fx identique : %v\n",
+				name, edge, duration1, duration2, float64(duration1)/float64(duration2), out1.equal(out2))
+		}
+	}
+	fmt.Println()
+}
+
+// CheckConvolveSeparable vérifie, pour chaque gestion de bord, qu'un noyau
+// séparable donne (à l'arrondi près) le même résultat que le noyau 2D
+// équivalent, y compris sur des images plus petites que le noyau.
+func CheckConvolveSeparable() bool {
+	fmt.Println("=== TEST convolution séparable vs 2D ===")
+	rng := rand.New(rand.NewSource(1))
+	x := []float32{0.1, 0.2, 0.4, 0.2, 0.1}
+	y := []float32{0.25, 0.5, 0.25}
+	weights := make([]float32, len(x)*len(y))
+	for j, wy := range y {
+		for i, wx := range x {
+			weights[j*len(x)+i] = wx * wy
+		}
+	}
+	full, _ := newConvKernel(len(x), len(y), weights)
+	sep := separableKernel(x, y)
+
+	ok := true
+	for _, size := range [][2]int{{1, 1}, {2, 3}, {4, 1}, {37, 23}} {
+		buf := newPixelBuffer(size[0], size[1])
+		for i := range buf.Pix {
+			buf.Pix[i] = Pixel{R: uint16(rng.Intn(65536)), G: uint16(rng.Intn(65536)), B: uint16(rng.Intn(65536))}
+		}
+		for _, edge := range edgeModes {
+			a, b := convolvePixels(buf, sep, edge), convolvePixels(buf, full, edge)
+			for i := range a.Pix {
+				p, q := a.Pix[i], b.Pix[i]
+				if sqDist(int(p.R), int(p.G), int(p.B), int(q.R), int(q.G), int(q.B)) > 3 {
+					fmt.Printf("écart %dx%d %s pixel %d : %v vs %v\n", size[0], size[1], edge, i, p, q)
+					ok = false
+					break
+				}
+			}
+		}
+	}
+	fmt.Println("Séparable et 2D identiques (à l'arrondi près) :", ok)
+	fmt.Println()
+	return ok
+}
+
+// CompareDenoise compare denoisePixels et denoisePixelsParallel (résultats
+// attendus identiques), la médiane pour plusieurs rayons.
+func CompareDenoise(buf *PixelBuffer) {
+	fmt.Println("=== TEST denoisePixels (SÉQUENTIEL vs PARALLÈLE) ===")
+	cases := []denoiseOptions{
+		{Method: "median", Radius: 1},
+		{Method: "median", Radius: 5},
+		{Method: "median", Radius: 20},
+		{Method: "bilateral", SigmaSpace: 3, SigmaRange: 10},
+	}
+	for _, opts := range cases {
+		start1 := time.Now()
+		out1 := denoisePixels(buf, opts)
+		duration1 := time.Since(start1)
+
+		start2 := time.Now()
+		out2 := denoisePixelsParallel(buf, opts)
+		duration2 := time.Since(start2)
+
+		label := fmt.Sprintf("median rayon %d", opts.Radius)
+		if opts.Method == "bilateral" {
+			label = fmt.Sprintf("bilateral %v px / %v%%", opts.SigmaSpace, opts.SigmaRange)
+		}
+		fmt.Printf("%-22s séquentiel %-14v parallèle %-14v speedup %.2fx identique : %v\n",
+			label, duration1, duration2, float64(duration1)/float64(duration2), out1.equal(out2))
+	}
+	fmt.Println()
+}
+
+// CheckMedianFilter compare le médian par histogramme glissant à un calcul
+// direct (tri de la fenêtre) sur de petites images aléatoires, y compris des
+// rayons plus grands que l'image.
+func CheckMedianFilter() bool {
+	fmt.Println("=== TEST médian par histogramme vs tri ===")
+	rng := rand.New(rand.NewSource(1))
+	ok := true
+	for _, c := range [][3]int{{1, 1, 1}, {7, 5, 1}, {9, 4, 3}, {3, 11, 6}} {
+		width, height, radius := c[0], c[1], c[2]
+		buf := newPixelBuffer(width, height)
+		for i := range buf.Pix {
+			// Peu de valeurs distinctes : beaucoup d'égalités dans les fenêtres
+			buf.Pix[i] = Pixel{R: uint16(rng.Intn(8)) * 9000, G: uint16(rng.Intn(65536)), B: uint16(rng.Intn(300))}
+		}
+		got := denoisePixels(buf, denoiseOptions{Method: "median", Radius: radius})
+		for y := 0; y < height && ok; y++ {
+			for x := 0; x < width; x++ {
+				var r, g, b []int
+				for dy := -radius; dy <= radius; dy++ {
+					for dx := -radius; dx <= radius; dx++ {
+						p := buf.neighbor(x+dx, y+dy)
+						r, g, b = append(r, int(p.R)), append(g, int(p.G)), append(b, int(p.B))
+					}
+				}
+				slices.Sort(r)
+				slices.Sort(g)
+				slices.Sort(b)
+				mid := len(r) / 2
+				want := Pixel{R: uint16(r[mid]), G: uint16(g[mid]), B: uint16(b[mid])}
+				if p := got.Pix[got.offset(x, y)]; p != want {
+					fmt.Printf("%dx%d rayon %d, pixel (%d, %d) : %v, attendu %v\n", width, height, radius, x, y, p, want)
+					ok = false
+					break
+				}
+			}
+		}
+	}
+	fmt.Println("Médian identique au tri :", ok)
+	fmt.Println()
+	return ok
+}
+
+// CompareAdjust compare adjustPixels, adjustPixelsParallel et le calcul direct
+// de la courbe pour chaque pixel (sans table), qui doivent donner la même image.
+func CompareAdjust(buf *PixelBuffer) {
+	fmt.Println("=== TEST adjustPixels (TABLES, SÉQUENTIEL vs PARALLÈLE vs CALCUL DIRECT) ===")
+	opts := defaultAdjustOptions
+	opts.Exposure = 0.5
+	opts.Contrast = 20
+	opts.Gamma = 1.2
+	opts.Levels[2] = levelsRange{InBlack: 5, InWhite: 95, OutBlack: 0, OutWhite: 90}
+
+	start1 := time.Now()
+	out1 := adjustPixels(buf.clone(), opts)
+	duration1 := time.Since(start1)
+
+	start2 := time.Now()
+	out2 := adjustPixelsParallel(buf.clone(), opts)
+	duration2 := time.Since(start2)
+
+	start3 := time.Now()
+	out3 := buf.clone()
+	curves := [3]func(float64) float64{adjustCurve(opts, 0), adjustCurve(opts, 1), adjustCurve(opts, 2)}
+	apply := func(c int, v uint16) uint16 {
+		return uint16(math.Round(curves[c](float64(v)/65535) * 65535))
+	}
+	for y := 0; y < out3.Height; y++ {
+		row := out3.row(y)
+		for x, p := range row {
+			row[x] = Pixel{R: apply(0, p.R), G: apply(1, p.G), B: apply(2, p.B)}
+		}
+	}
+	duration3 := time.Since(start3)
+
+	fmt.Printf("Tables séquentiel : %v, parallèle : %v, calcul direct : %v\n", duration1, duration2, duration3)
+	fmt.Printf("Speedup parallèle : %.2fx, tables vs calcul direct : %.2fx\n",
+		float64(duration1)/float64(duration2), float64(duration3)/float64(duration1))
+	fmt.Println("Résultats identiques :", out1.equal(out2) && out1.equal(out3))
+	fmt.Println()
+}
+
+// CompareEqualize compare equalizePixels et equalizePixelsParallel (égalisation
+// globale et CLAHE), affiche l'étalement de la luminance obtenu et vérifie que
+// les écarts de couleur R-Y et B-Y sont conservés (hors saturation)
+func CompareEqualize(buf *PixelBuffer) {
+	fmt.Println("=== TEST equalizePixels (SÉQUENTIEL vs PARALLÈLE) ===")
+	lumaStdDev := func(b *PixelBuffer) float64 {
+		luma := grayPlane(b, defaultGrayOptions, nil)
+		var sum, sum2 float64
+		for _, v := range luma {
+			sum += float64(v)
+			sum2 += float64(v) * float64(v)
+		}
+		mean := sum / float64(len(luma))
+		return math.Sqrt(sum2/float64(len(luma))-mean*mean) / 65535 * 100
+	}
+	// Écart moyen de R-Y et B-Y entre l'entrée et la sortie, en % de la dynamique
+	chromaShift := func(out *PixelBuffer) float64 {
+		inLuma := grayPlane(buf, defaultGrayOptions, nil)
+		outLuma := grayPlane(out, defaultGrayOptions, nil)
+		var shift float64
+		for y := 0; y < buf.Height; y++ {
+			for x := 0; x < buf.Width; x++ {
+				p, q := buf.Pix[buf.offset(x, y)], out.Pix[out.offset(x, y)]
+				i := y*buf.Width + x
+				dr := (float64(q.R) - float64(outLuma[i])) - (float64(p.R) - float64(inLuma[i]))
+				db := (float64(q.B) - float64(outLuma[i])) - (float64(p.B) - float64(inLuma[i]))
+				shift += math.Abs(dr) + math.Abs(db)
+			}
+		}
+		return shift / float64(2*len(inLuma)) / 65535 * 100
+	}
+	fmt.Printf("Écart type de la luminance en entrée : %.1f%%\n", lumaStdDev(buf))
+
+	for _, method := range equalizeMethods {
+		opts := defaultEqualizeOptions
+		opts.Method = method
+
+		start1 := time.Now()
+		out1 := equalizePixels(buf.clone(), opts)
+		duration1 := time.Since(start1)
+
+		start2 := time.Now()
+		out2 := equalizePixelsParallel(buf.clone(), opts)
+		duration2 := time.Since(start2)
+
+		fmt.Printf("[%s] Séquentiel : %v, parallèle : %v (speedup %.2fx)\n",
+			method, duration1, duration2, float64(duration1)/float64(duration2))
+		fmt.Printf("[%s] Écart type de la luminance : %.1f%%, dérive de R-Y/B-Y : %.2f%%\n",
+			method, lumaStdDev(out1), chromaShift(out1))
+		fmt.Printf("[%s] Résultats identiques : %v\n", method, out1.equal(out2))
+	}
+	fmt.Println()
+}
+
+// CompareMatch compare matchPixels et matchPixelsParallel pour chaque méthode
+// et affiche la moyenne et l'écart type des canaux R, G, B (en % de la
+// dynamique) de la source, de la cible et des résultats
+func CompareMatch(src, target *PixelBuffer) {
+	fmt.Println("=== TEST matchPixels (SÉQUENTIEL vs PARALLÈLE) ===")
+	stats := func(b *PixelBuffer) string {
+		var sum, sum2 [3]float64
+		for y := 0; y < b.Height; y++ {
+			for _, p := range b.row(y) {
+				for c := 0; c < 3; c++ {
+					v := float64(p.channel(c)) / 65535 * 100
+					sum[c] += v
+					sum2[c] += v * v
+				}
+			}
+		}
+		n := float64(b.Width * b.Height)
+		s := ""
+		for c, name := range []string{"R", "G", "B"} {
+			mean := sum[c] / n
+			s += fmt.Sprintf(" %s %.1f±%.1f", name, mean, math.Sqrt(sum2[c]/n-mean*mean))
+		}
+		return s
+	}
+	fmt.Println("Source :" + stats(src))
+	fmt.Println("Cible  :" + stats(target))
+
+	for _, method := range matchMethods {
+		opts := matchOptions{Method: method}
+
+		start1 := time.Now()
+		out1 := matchPixels(src, target, opts)
+		duration1 := time.Since(start1)
+
+		start2 := time.Now()
+		out2 := matchPixelsParallel(src, target, opts)
+		duration2 := time.Since(start2)
+
+		fmt.Printf("[%s] Séquentiel : %v, parallèle : %v (speedup %.2fx)\n",
+			method, duration1, duration2, float64(duration1)/float64(duration2))
+		fmt.Printf("[%s] Résultat :%s\n", method, stats(out1))
+		fmt.Printf("[%s] Résultats identiques : %v\n", method, out1.equal(out2))
+	}
+	fmt.Println()
+}
+
+// CheckColorSpaces vérifie les conversions d'espaces de couleur : aller-retour
+// sur des pixels aléatoires (écart maximal inférieur à une unité 8 bits), valeurs connues
+// du blanc et du noir en Lab, paires de référence CIEDE2000, et gris obtenu en pixelisant un damier noir et
+// blanc en sRGB puis en lumière linéaire.
+func CheckColorSpaces() bool {
+	fmt.Println("=== VÉRIFICATION DES ESPACES DE COULEUR ===")
+	rng := rand.New(rand.NewSource(3))
+	roundTrips := []struct {
+		name string
+		conv func(Pixel) Pixel
+	}{
+		{"linéaire", func(p Pixel) Pixel { return linearPixel(p.linearRGB()) }},
+		{"HSV", func(p Pixel) Pixel { return hsvPixel(p.hsv()) }},
+		{"HSL", func(p Pixel) Pixel { return hslPixel(p.hsl()) }},
+		{"XYZ", func(p Pixel) Pixel { return xyzPixel(p.xyz()) }},
+		{"Lab", func(p Pixel) Pixel { return labPixel(p.lab()) }},
+		{"YCbCr", func(p Pixel) Pixel { return ycbcrPixel(p.ycbcr()) }},
+	}
+	pixels := make([]Pixel, 10000)
+	for i := range pixels {
+		pixels[i] = Pixel{R: uint16(rng.Intn(65536)), G: uint16(rng.Intn(65536)), B: uint16(rng.Intn(65536))}
+	}
+	ok := true
+	for _, rt := range roundTrips {
+		worst := 0
+		for _, p := range pixels {
+			q := rt.conv(p)
+			for c := 0; c < 3; c++ {
+				worst = max(worst, int(q.channel(c))-int(p.channel(c)), int(p.channel(c))-int(q.channel(c)))
+			}
+		}
+		fmt.Printf("Aller-retour %s : écart max %d (sur 65535)\n", rt.name, worst)
+		ok = ok && worst < 257
+	}
+
+	white, black := Pixel{R: 65535, G: 65535, B: 65535}, Pixel{}
+	wl, wa, wb := white.lab()
+	bl, _, _ := black.lab()
+	fmt.Printf("Lab du blanc : (%.2f, %.2f, %.2f), L du noir : %.2f\n", wl, wa, wb, bl)
+	ok = ok && math.Abs(wl-100) < 0.01 && math.Abs(wa) < 0.01 && math.Abs(wb) < 0.01 && bl == 0
+
+	// Paires de référence de Sharma, Wu et Dalal (2005)
+	for _, ref := range []struct {
+		a, b [3]float64
+		de   float64
+	}{
+		{[3]float64{50, 2.6772, -79.7751}, [3]float64{50, 0, -82.7485}, 2.0425},
+		{[3]float64{50, 2.5, 0}, [3]float64{73, 25, -18}, 27.1492},
+		{[3]float64{50, -0.001, 2.49}, [3]float64{50, 0.0011, -2.49}, 4.7461},
+		{[3]float64{2.0776, 0.0795, -1.135}, [3]float64{0.9033, -0.0636, -0.5514}, 0.9082},
+	} {
+		de := ciede2000(ref.a, ref.b)
+		fmt.Printf("CIEDE2000 %v / %v : %.4f (attendu %.4f)\n", ref.a, ref.b, de, ref.de)
+		ok = ok && math.Abs(de-ref.de) < 0.0001
+	}
+
+	checker := newPixelBuffer(2, 2)
+	checker.Pix[0], checker.Pix[3] = white, white
+	gamma := downscalePixels(checker, 2).Pix[0].R
+	linear := downscalePixelsLinear(checker, 2).Pix[0].R
+	fmt.Printf("Damier noir/blanc pixelisé : %.1f%% en sRGB, %.1f%% en lumière linéaire (attendu 73.5%%)\n",
+		float64(gamma)/655.35, float64(linear)/655.35)
+	ok = ok && math.Abs(float64(linear)/65535-encodeSRGB(0.5)) < 0.001
+
+	fmt.Printf("Conversions correctes : %v\n\n", ok)
+	return ok
+}
+
+// CompareRemapDistances compare les distances de colorMetrics pour le repli
+// sur les bins épuisés : temps du remap et écart moyen (ΔE76 et ΔE2000) entre
+// chaque pixel du résultat et le pixel de la cible à la même position (8
+// niveaux, 512 bins).
+func CompareRemapDistances(src, target *PixelBuffer) {
+	fmt.Println("=== TEST remapPixels (DISTANCES DU REPLI) ===")
+	targetLab := labPlane(target)
+	for _, distance := range colorMetricNames {
+		opts := defaultRemapOptions
+		opts.Levels, opts.Distance = 8, distance
+
+		start := time.Now()
+		out := remapPixels(src, target, opts)
+		duration := time.Since(start)
+		if out == nil {
+			fmt.Printf("%-10s ÉCHEC : remap impossible (%v)\n", distance, checkRemap(opts))
+			continue
+		}
+
+		de76, de2000 := meanDeltaE(out, targetLab)
+		fmt.Printf("%-10s Temps : %-14v ΔE76 moyen : %6.3f  ΔE2000 moyen : %6.3f\n", distance, duration, de76, de2000)
+	}
+	fmt.Println()
+}
+
+// labPlane retourne les coordonnées Lab des pixels de buf, ligne par ligne
+func labPlane(buf *PixelBuffer) [][3]float64 {
+	plane := make([][3]float64, 0, buf.Width*buf.Height)
+	for y := 0; y < buf.Height; y++ {
+		for _, p := range buf.row(y) {
+			plane = append(plane, labCoords(p))
+		}
+	}
+	return plane
+}
+
+// meanDeltaE retourne l'écart moyen (ΔE76 et ΔE2000) entre chaque pixel de
+// out et le point de ref (labPlane de la cible) à la même position
+func meanDeltaE(out *PixelBuffer, ref [][3]float64) (de76, de2000 float64) {
+	for y := 0; y < out.Height; y++ {
+		for x, p := range out.row(y) {
+			lab, t := labCoords(p), ref[y*out.Width+x]
+			de76 += math.Sqrt(sqEuclid(lab, t))
+			de2000 += ciede2000(lab, t)
+		}
+	}
+	n := float64(len(ref))
+	return de76 / n, de2000 / n
+}
+
+// CompareNearestBin compare, pour plusieurs nombres de niveaux et distances,
+// la recherche du bin non vide le plus proche dans le k-d tree de binSupply et
+// le parcours de tous les bins (scanNearestBin). Les requêtes portent sur des
+// bins épuisés, une fois la moitié de la cible servie ; les deux méthodes
+// doivent choisir les mêmes bins. Le temps du remap complet est aussi affiché.
+func CompareNearestBin(src, target *PixelBuffer) {
+	fmt.Println("=== TEST binSupply (k-d tree vs PARCOURS DE TOUS LES BINS) ===")
+	var cases []remapOptions
+	add := func(levels int, distance string) {
+		opts := defaultRemapOptions
+		opts.Levels, opts.Distance = levels, distance
+		cases = append(cases, opts)
+	}
+	for _, levels := range []int{8, 16, 32, 64} {
+		add(levels, "rgb")
+	}
+	for _, distance := range colorMetricNames[1:] {
+		add(16, distance)
+	}
+	const queries = 1000
+	for _, opts := range cases {
+		rng := rand.New(rand.NewSource(4))
+		supply := newBinSupply(src, opts.Levels, opts.Distance)
+		order := rng.Perm(target.Width * target.Height)
+		for _, i := range order[:len(order)/2] {
+			supply.pop(quantizePixel(target.Pix[target.offset(i%target.Width, i/target.Width)], opts.Levels))
+		}
+		var empty []int
+		for len(empty) < queries {
+			if bin := rng.Intn(len(supply.bins)); len(supply.bins[bin]) == 0 {
+				empty = append(empty, bin)
+			}
+		}
+
+		start1 := time.Now()
+		expected := make([]int, len(empty))
+		for i, bin := range empty {
+			expected[i] = scanNearestBin(supply, bin)
+		}
+		duration1 := time.Since(start1)
+
+		for i := range supply.cache {
+			supply.cache[i] = -1 // mesure la recherche dans l'arbre, sans le cache
+		}
+		start2 := time.Now()
+		identical := true
+		for i, bin := range empty {
+			identical = identical && supply.nearest(bin) == expected[i]
+		}
+		duration2 := time.Since(start2)
+
+		start3 := time.Now()
+		out := remapPixels(src, target, opts)
+		duration3 := time.Since(start3)
+		if out == nil {
+			fmt.Printf("%2d niveaux %-10s ÉCHEC : remap impossible (%v)\n", opts.Levels, opts.Distance, checkRemap(opts))
+			continue
+		}
+
+		fmt.Printf("%2d niveaux %-10s parcours %8.2fµs/requête  k-d tree %7.2fµs/requête (%6.1fx)  remap %-14v identiques : %v\n",
+			opts.Levels, opts.Distance,
+			float64(duration1.Microseconds())/queries, float64(duration2.Microseconds())/queries,
+			float64(duration1)/float64(duration2), duration3, identical)
+	}
+	fmt.Println()
+}
+
+// sortedPixels retourne les pixels de b triés (R, puis G, puis B) : deux
+// buffers sont des permutations l'un de l'autre si leurs pixels triés sont égaux
+func sortedPixels(b *PixelBuffer) []Pixel {
+	var pixels []Pixel
+	for y := 0; y < b.Height; y++ {
+		pixels = append(pixels, b.row(y)...)
+	}
+	slices.SortFunc(pixels, func(a, b Pixel) int {
+		return cmp.Or(cmp.Compare(a.R, b.R), cmp.Compare(a.G, b.G), cmp.Compare(a.B, b.B))
+	})
+	return pixels
+}
+
+// CheckRemapPlan vérifie les garanties du remap planifié, en séquentiel et
+// en parallèle (plusieurs nombres de workers) : le résultat est une
+// permutation de la source, chaque bin de la cible reçoit autant de ses
+// propres pixels que possible (somme des min(demande, réserve)), et le
+// résultat ne dépend que de la graine. L'ancien
+// remap glouton (positions mélangées, un pop verrouillé par position) sert de
+// référence de temps et de nombre de positions servies par leur propre bin.
+func CheckRemapPlan(src, target *PixelBuffer) bool {
+	fmt.Println("=== TEST remapPixels (PLANIFICATION) ===")
+	opts := defaultRemapOptions
+	levels := opts.Levels
+	demand := buildTargetHistogram(target, levels)
+	supply := buildSourceBins(src, levels)
+	best := 0
+	for bin, n := range demand {
+		best += min(n, len(supply[bin]))
+	}
+	srcPixels := sortedPixels(src)
+	check := func(name string, out *PixelBuffer, duration time.Duration) bool {
+		own := 0
+		for y := 0; y < out.Height; y++ {
+			for x, p := range out.row(y) {
+				if quantizePixel(p, levels) == quantizePixel(target.Pix[target.offset(x, y)], levels) {
+					own++
+				}
+			}
+		}
+		permutation := slices.Equal(sortedPixels(out), srcPixels)
+		fmt.Printf("%-22s Temps : %-14v permutation : %-5v propre bin : %d/%d\n", name, duration, permutation, own, best)
+		return permutation && own == best
+	}
+
+	start := time.Now()
+	greedy := newPixelBuffer(src.Width, src.Height)
+	positions := rand.Perm(src.Width * src.Height)
+	greedySupply := newBinSupply(src, levels, opts.Distance)
+	var mu sync.Mutex
+	parallelExec.Rows(len(positions), func(start, end int) {
+		for _, i := range positions[start:end] {
+			x, y := i%src.Width, i/src.Width
+			mu.Lock()
+			p, _ := greedySupply.pop(quantizePixel(target.Pix[target.offset(x, y)], levels))
+			mu.Unlock()
+			greedy.Pix[greedy.offset(x, y)] = p
+		}
+	})
+	check("glouton (mutex)", greedy, time.Since(start)) // propre bin < optimum attendu
+
+	ok := true
+	start = time.Now()
+	reference := remapPixels(src, target, opts)
+	ok = check("planifié séquentiel", reference, time.Since(start)) && ok
+	for _, workers := range []int{2, 4, 8} {
+		exec := Executor{Workers: workers, Grain: 7}
+		start = time.Now()
+		out := remap(src, target, opts, &exec)
+		ok = check(fmt.Sprintf("planifié %d workers", workers), out, time.Since(start)) && ok
+		identical := out.equal(reference)
+		fmt.Printf("%-22s identique au séquentiel : %v\n", "", identical)
+		ok = ok && identical
+	}
+	opts.Seed++
+	other := !remapPixels(src, target, opts).equal(reference)
+	fmt.Printf("Graine %d : résultat différent : %v\n\n", opts.Seed, other)
+	return ok && other
+}
+
+// CheckHilbertCurve vérifie hilbertIndex sur un cube de 16³ points : chaque
+// point a un index distinct, et deux index consécutifs sont des points voisins
+// (une seule coordonnée change, de 1).
+func CheckHilbertCurve() bool {
+	fmt.Println("=== TEST courbe de Hilbert (16³ points) ===")
+	const bits = 4
+	const side = 1 << bits
+	points := make([][3]uint32, side*side*side)
+	seen := make([]bool, len(points))
+	ok := true
+	for r := uint32(0); r < side; r++ {
+		for g := uint32(0); g < side; g++ {
+			for b := uint32(0); b < side; b++ {
+				i := hilbertIndex([3]uint32{r, g, b}, bits)
+				if i >= uint64(len(points)) || seen[i] {
+					ok = false
+					continue
+				}
+				seen[i] = true
+				points[i] = [3]uint32{r, g, b}
+			}
+		}
+	}
+	for i := 1; ok && i < len(points); i++ {
+		steps := 0
+		for c := range 3 {
+			a, b := int(points[i][c]), int(points[i-1][c])
+			steps += max(a-b, b-a)
+		}
+		ok = steps == 1
+	}
+	fmt.Printf("Bijection et voisinage : %v\n\n", ok)
+	return ok
+}
+
+// CompareRemapMethods compare les méthodes de remap : temps séquentiel et
+// parallèle, résultats identiques, permutation de la source, et écart moyen
+// (ΔE76 et ΔE2000) avec la cible à la même position.
+func CompareRemapMethods(src, target *PixelBuffer) {
+	fmt.Println("=== TEST remapPixels (MÉTHODES) ===")
+	targetLab := labPlane(target)
+	srcPixels := sortedPixels(src)
+	var cases []remapOptions
+	for _, method := range remapMethods {
+		opts := defaultRemapOptions
+		opts.Method = method
+		cases = append(cases, opts)
+	}
+	// Sans itération : appariement direct le long de la courbe de Hilbert
+	hilbert := defaultRemapOptions
+	hilbert.Method, hilbert.Iterations = "sliced", 0
+	cases = append(cases, hilbert)
+	for _, opts := range cases {
+		name := opts.Method
+		if name == "sliced" {
+			name = fmt.Sprintf("sliced %d it.", opts.Iterations)
+		}
+
+		start1 := time.Now()
+		out1 := remapPixels(src, target, opts)
+		duration1 := time.Since(start1)
+
+		start2 := time.Now()
+		out2 := remapPixelsParallel(src, target, opts)
+		duration2 := time.Since(start2)
+
+		de76, de2000 := meanDeltaE(out1, targetLab)
+		fmt.Printf("%-15s Séquentiel : %-14v parallèle : %-14v identiques : %-5v permutation : %-5v ΔE76 moyen : %6.3f  ΔE2000 moyen : %6.3f\n",
+			name, duration1, duration2, out1.equal(out2), slices.Equal(sortedPixels(out1), srcPixels), de76, de2000)
+	}
+	fmt.Println()
+}
+
+// CompareRemapRefine compare, pour chaque méthode de remap, le résultat sans
+// affinage et avec affinage (cible nette, puis floutée) : temps, résultats
+// séquentiel et parallèle identiques, permutation de la source, écart moyen
+// (ΔE76) avec la cible et avec la cible floutée (sigma 2), et grain : écart
+// moyen (ΔE76) entre pixels voisins, à comparer à celui de la cible. Retourne
+// false si un résultat n'est pas une permutation de la source ou diffère
+// entre séquentiel et parallèle.
+func CompareRemapRefine(src, target *PixelBuffer) bool {
+	fmt.Println("=== TEST remapPixels (AFFINAGE PAR ÉCHANGES) ===")
+	blur := defaultFilterOptions
+	blur.Sigma = 2
+	targetLab := labPlane(target)
+	blurredLab := labPlane(filterPixels(target, blur))
+	srcPixels := sortedPixels(src)
+	grain := func(b *PixelBuffer) float64 {
+		lab := labPlane(b)
+		var sum float64
+		for y := 0; y < b.Height; y++ {
+			for x := 1; x < b.Width; x++ {
+				sum += math.Sqrt(sqEuclid(lab[y*b.Width+x], lab[y*b.Width+x-1]))
+			}
+		}
+		return sum / float64(b.Height*(b.Width-1))
+	}
+	fmt.Printf("Grain de la cible : %.3f\n", grain(target))
+
+	ok := true
+	for _, method := range remapMethods {
+		for _, refine := range []struct {
+			passes int
+			sigma  float64
+		}{{0, 0}, {10, 0}, {10, 2}} {
+			opts := defaultRemapOptions
+			opts.Method, opts.RefinePasses, opts.RefineSigma = method, refine.passes, refine.sigma
+
+			start1 := time.Now()
+			out1 := remapPixels(src, target, opts)
+			duration1 := time.Since(start1)
+
+			start2 := time.Now()
+			out2 := remapPixelsParallel(src, target, opts)
+			duration2 := time.Since(start2)
+
+			if out1 == nil {
+				fmt.Printf("%-6s %2d passes sigma %v  ÉCHEC : remap impossible (%v)\n", method, refine.passes, refine.sigma, checkRemap(opts))
+				ok = false
+				continue
+			}
+			identical := out1.equal(out2)
+			permutation := slices.Equal(sortedPixels(out1), srcPixels)
+			ok = ok && identical && permutation
+			de, _ := meanDeltaE(out1, targetLab)
+			deBlur, _ := meanDeltaE(out1, blurredLab)
+			fmt.Printf("%-6s %2d passes sigma %v  Séquentiel : %-14v parallèle : %-14v identiques : %-5v permutation : %-5v ΔE76 : %6.3f  ΔE76 flou : %6.3f  grain : %6.3f\n",
+				method, refine.passes, refine.sigma, duration1, duration2, identical,
+				permutation, de, deBlur, grain(out1))
+		}
+	}
+	fmt.Println()
+	return ok
 }
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// convKernel est un noyau de convolution de dimensions impaires, centré sur
+// le pixel traité. Un noyau séparable est donné par ses deux vecteurs : la
+// convolution se fait alors en deux passes 1D, bien moins coûteuses pour les
+// grands rayons.
+type convKernel struct {
+	width, height int
+	weights       []float32 // non séparable : height lignes de width poids
+	x, y          []float32 // séparable : poids horizontaux (width) et verticaux (height)
+	bias          float32   // ajouté au résultat (ex: gris moyen de l'estampage)
+}
+
+// newConvKernel retourne le noyau non séparable width x height de poids
+// weights (ligne par ligne)
+func newConvKernel(width, height int, weights []float32) (convKernel, error) {
+	if width < 1 || height < 1 || width%2 == 0 || height%2 == 0 {
+		return convKernel{}, fmt.Errorf("noyau %dx%d invalide (dimensions impaires attendues)", width, height)
+	}
+	if len(weights) != width*height {
+		return convKernel{}, fmt.Errorf("noyau %dx%d : %d poids attendus, %d reçus", width, height, width*height, len(weights))
+	}
+	return convKernel{width: width, height: height, weights: weights}, nil
+}
+
+// separableKernel retourne le noyau séparable de vecteurs x (horizontal) et
+// y (vertical), tous deux de longueur impaire
+func separableKernel(x, y []float32) convKernel {
+	return convKernel{width: len(x), height: len(y), x: x, y: y}
+}
+
+// separable indique si le noyau est donné par ses deux vecteurs
+func (k convKernel) separable() bool {
+	return k.x != nil
+}
+
+// edgeModes liste les gestions de bord acceptées (paramètre "edge") : le
+// pixel hors image est celui du bord ("clamp"), du bord opposé ("wrap"), son
+// reflet sans répéter le bord ("mirror"), ou noir ("zero").
+var edgeModes = []string{"clamp", "wrap", "mirror", "zero"}
+
+// checkEdge retourne une erreur si le mode de bord est inconnu
+func checkEdge(mode string) error {
+	switch mode {
+	case "clamp", "wrap", "mirror", "zero":
+		return nil
+	}
+	return fmt.Errorf("gestion de bord inconnue %q", mode)
+}
+
+// edgeTable retourne, pour les positions -radius .. n-1+radius d'un axe de
+// longueur n, l'index du pixel à lire selon le mode de bord (-1 : pixel noir).
+func edgeTable(n, radius int, mode string) []int {
+	table := make([]int, n+2*radius)
+	for i := range table {
+		p := i - radius
+		switch {
+		case p >= 0 && p < n:
+		case mode == "zero":
+			p = -1
+		case mode == "wrap":
+			p = ((p % n) + n) % n
+		case mode == "mirror" && n > 1:
+			period := 2 * (n - 1)
+			p = ((p % period) + period) % period
+			if p >= n {
+				p = period - p
+			}
+		default: // clamp, ou mirror sur un axe d'un seul pixel
+			p = min(max(p, 0), n-1)
+		}
+		table[i] = p
+	}
+	return table
+}
+
+// convolvePixels applique le noyau k à l'image (séquentiel). Retourne un
+// nouveau buffer, ou nil si le mode de bord est inconnu.
+func convolvePixels(buf *PixelBuffer, k convKernel, edge string) *PixelBuffer {
+	return convolve(buf, k, edge, nil)
+}
+
+// convolve est l'implémentation commune : exec nil = séquentiel, sinon chaque
+// passe est répartie par bandes de lignes. Chaque pixel de sortie ne dépend
+// que de l'entrée : le résultat ne dépend pas du découpage.
+func convolve(buf *PixelBuffer, k convKernel, edge string, exec *Executor) *PixelBuffer {
+	if checkEdge(edge) != nil {
+		return nil
+	}
+	width, height := buf.Width, buf.Height
+	out := newPixelBuffer(width, height)
+	if buf.empty() {
+		return out
+	}
+	rx, ry := k.width/2, k.height/2
+	edgeX, edgeY := edgeTable(width, rx, edge), edgeTable(height, ry, edge)
+
+	if !k.separable() {
+		runRows(exec, height, func(start, end int) {
+			for y := start; y < end; y++ {
+				row := out.row(y)
+				for x := range row {
+					var r, g, b float32
+					for ky := 0; ky < k.height; ky++ {
+						sy := edgeY[y+ky]
+						if sy < 0 {
+							continue
+						}
+						in := buf.row(sy)
+						weights := k.weights[ky*k.width : (ky+1)*k.width]
+						for kx, w := range weights {
+							sx := edgeX[x+kx]
+							if sx < 0 {
+								continue
+							}
+							p := in[sx]
+							r += w * float32(p.R)
+							g += w * float32(p.G)
+							b += w * float32(p.B)
+						}
+					}
+					row[x] = Pixel{R: clampChannel(r + k.bias), G: clampChannel(g + k.bias), B: clampChannel(b + k.bias)}
+				}
+			}
+		})
+		return out
+	}
+
+	// Passe horizontale : tmp contient 3 canaux flottants par pixel
+	tmp := make([]float32, 3*width*height)
+	runRows(exec, height, func(start, end int) {
+		for y := start; y < end; y++ {
+			in := buf.row(y)
+			line := tmp[3*width*y : 3*width*(y+1)]
+			for x := 0; x < width; x++ {
+				var r, g, b float32
+				for kx, w := range k.x {
+					sx := edgeX[x+kx]
+					if sx < 0 {
+						continue
+					}
+					p := in[sx]
+					r += w * float32(p.R)
+					g += w * float32(p.G)
+					b += w * float32(p.B)
+				}
+				line[3*x], line[3*x+1], line[3*x+2] = r, g, b
+			}
+		}
+	})
+	// Passe verticale
+	runRows(exec, height, func(start, end int) {
+		for y := start; y < end; y++ {
+			row := out.row(y)
+			for x := range row {
+				var r, g, b float32
+				for ky, w := range k.y {
+					sy := edgeY[y+ky]
+					if sy < 0 {
+						continue
+					}
+					i := 3 * (width*sy + x)
+					r += w * tmp[i]
+					g += w * tmp[i+1]
+					b += w * tmp[i+2]
+				}
+				row[x] = Pixel{R: clampChannel(r + k.bias), G: clampChannel(g + k.bias), B: clampChannel(b + k.bias)}
+			}
+		}
+	})
+	return out
+}
+
+// filterOptions décrit un filtre spatial prédéfini.
+type filterOptions struct {
+	// Filter : "box" (moyenne sur 2*Radius+1), "gaussian" (Sigma), "sharpen"
+	// (3x3, Amount), "unsharp" (masque flou : gaussienne Sigma, Amount),
+	// "emboss" (estampage) ou "custom" (Kernel).
+	Filter string
+	// Radius est le rayon du flou "box".
+	Radius int
+	// Sigma est l'écart type (en pixels) de "gaussian" et "unsharp".
+	Sigma float64
+	// Amount est l'intensité de "sharpen" et "unsharp", en % (100 par défaut).
+	Amount float64
+	// Edge est la gestion des bords (voir edgeModes).
+	Edge string
+	// Kernel est le noyau du filtre "custom".
+	Kernel convKernel
+}
+
+// defaultFilterOptions : flou gaussien de sigma 2, bords répétés.
+var defaultFilterOptions = filterOptions{
+	Filter: "gaussian",
+	Radius: 2,
+	Sigma:  2,
+	Amount: 100,
+	Edge:   "clamp",
+}
+
+// filterNames liste les filtres acceptés par filterOptions.Filter.
+var filterNames = []string{"box", "gaussian", "sharpen", "unsharp", "emboss", "custom"}
+
+// maxFilterRadius borne le rayon des noyaux (box, et 3*sigma pour les gaussiennes)
+const maxFilterRadius = 200
+
+// maxCustomKernelSide borne la largeur et la hauteur d'un noyau "custom" :
+// non séparable, il coûte largeur*hauteur opérations par pixel.
+const maxCustomKernelSide = 15
+
+// filterKernel retourne le noyau correspondant aux options ; pour "unsharp",
+// c'est la gaussienne à soustraire de l'image.
+func filterKernel(opts filterOptions) (convKernel, error) {
+	if err := checkEdge(opts.Edge); err != nil {
+		return convKernel{}, err
+	}
+	switch opts.Filter {
+	case "box":
+		if opts.Radius < 1 || opts.Radius > maxFilterRadius {
+			return convKernel{}, fmt.Errorf("rayon %d hors de [1, %d]", opts.Radius, maxFilterRadius)
+		}
+		v := boxWeights(opts.Radius)
+		return separableKernel(v, v), nil
+	case "gaussian", "unsharp":
+		if opts.Sigma <= 0 || math.Ceil(3*opts.Sigma) > maxFilterRadius {
+			return convKernel{}, fmt.Errorf("sigma %v hors de ]0, %d]", opts.Sigma, maxFilterRadius/3)
+		}
+		if opts.Filter == "unsharp" && opts.Amount < 0 {
+			return convKernel{}, fmt.Errorf("intensité %v négative", opts.Amount)
+		}
+		v := gaussianWeights(opts.Sigma)
+		return separableKernel(v, v), nil
+	case "sharpen":
+		if opts.Amount < 0 {
+			return convKernel{}, fmt.Errorf("intensité %v négative", opts.Amount)
+		}
+		a := float32(opts.Amount / 100)
+		return newConvKernel(3, 3, []float32{
+			0, -a, 0,
+			-a, 1 + 4*a, -a,
+			0, -a, 0,
+		})
+	case "emboss":
+		k, err := newConvKernel(3, 3, []float32{
+			-1, -1, 0,
+			-1, 0, 1,
+			0, 1, 1,
+		})
+		k.bias = 32768 // relief autour du gris moyen
+		return k, err
+	case "custom":
+		if opts.Kernel.width == 0 {
+			return convKernel{}, fmt.Errorf("noyau personnalisé manquant")
+		}
+		if opts.Kernel.width > maxCustomKernelSide || opts.Kernel.height > maxCustomKernelSide {
+			return convKernel{}, fmt.Errorf("noyau personnalisé %dx%d trop grand (max %dx%d)",
+				opts.Kernel.width, opts.Kernel.height, maxCustomKernelSide, maxCustomKernelSide)
+		}
+		return opts.Kernel, nil
+	}
+	return convKernel{}, fmt.Errorf("filtre inconnu %q", opts.Filter)
+}
+
+// boxWeights retourne les 2*radius+1 poids égaux du flou box
+func boxWeights(radius int) []float32 {
+	v := make([]float32, 2*radius+1)
+	for i := range v {
+		v[i] = 1 / float32(len(v))
+	}
+	return v
+}
+
+// gaussianWeights retourne les poids normalisés d'une gaussienne d'écart type
+// sigma, tronquée à 3 sigmas
+func gaussianWeights(sigma float64) []float32 {
+	radius := max(1, int(math.Ceil(3*sigma)))
+	weights := make([]float64, 2*radius+1)
+	sum := 0.0
+	for i := range weights {
+		d := float64(i - radius)
+		weights[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		sum += weights[i]
+	}
+	v := make([]float32, len(weights))
+	for i, w := range weights {
+		v[i] = float32(w / sum)
+	}
+	return v
+}
+
+// parseKernel lit un noyau personnalisé : lignes séparées par ';', poids
+// séparés par ','. Les poids sont divisés par leur somme si elle n'est pas
+// nulle (un noyau de somme nulle, type détection de contours, est gardé tel quel).
+func parseKernel(spec string) (convKernel, error) {
+	lines := strings.Split(spec, ";")
+	// Rejeté avant d'analyser les nombres : la spécification vient du réseau
+	if len(lines) > maxCustomKernelSide || strings.Count(lines[0], ",") >= maxCustomKernelSide {
+		return convKernel{}, fmt.Errorf("noyau trop grand (max %dx%d)", maxCustomKernelSide, maxCustomKernelSide)
+	}
+	var weights []float32
+	width := -1
+	sum := float32(0)
+	for _, line := range lines {
+		fields := strings.Split(line, ",")
+		if width >= 0 && len(fields) != width {
+			return convKernel{}, fmt.Errorf("noyau %q : lignes de longueurs différentes", spec)
+		}
+		width = len(fields)
+		for _, f := range fields {
+			w, err := strconv.ParseFloat(strings.TrimSpace(f), 32)
+			if err != nil || math.IsNaN(w) || math.IsInf(w, 0) {
+				return convKernel{}, fmt.Errorf("noyau : nombre fini attendu, reçu %q", f)
+			}
+			weights = append(weights, float32(w))
+			sum += float32(w)
+		}
+	}
+	// Des poids finis peuvent encore déborder en float32 une fois additionnés
+	if math.IsNaN(float64(sum)) || math.IsInf(float64(sum), 0) {
+		return convKernel{}, fmt.Errorf("noyau %q : somme des poids non finie", spec)
+	}
+	if sum != 0 {
+		for i := range weights {
+			weights[i] /= sum
+		}
+	}
+	return newConvKernel(width, len(lines), weights)
+}
+
+// filterPixels applique le filtre décrit par opts (séquentiel). Retourne un
+// nouveau buffer, ou nil si les options sont invalides.
+func filterPixels(buf *PixelBuffer, opts filterOptions) *PixelBuffer {
+	return filter(buf, opts, nil)
+}
+
+// filter est l'implémentation commune : exec nil = séquentiel
+func filter(buf *PixelBuffer, opts filterOptions, exec *Executor) *PixelBuffer {
+	k, err := filterKernel(opts)
+	if err != nil {
+		return nil
+	}
+	out := convolve(buf, k, opts.Edge, exec)
+	if opts.Filter != "unsharp" {
+		return out
+	}
+
+	// Masque flou : out = image + amount * (image - flou)
+	amount := float32(opts.Amount / 100)
+	sharpen := func(c, blurred uint16) uint16 {
+		return clampChannel(float32(c) + amount*(float32(c)-float32(blurred)))
+	}
+	rows := func(start, end int) {
+		for y := start; y < end; y++ {
+			in, row := buf.row(y), out.row(y)
+			for x, p := range in {
+				row[x] = Pixel{R: sharpen(p.R, row[x].R), G: sharpen(p.G, row[x].G), B: sharpen(p.B, row[x].B)}
+			}
+		}
+	}
+	runRows(exec, buf.Height, rows)
+	return out
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// denoiseOptions décrit un débruitage qui préserve les contours.
+type denoiseOptions struct {
+	// Method : "median" (médiane de chaque canal sur une fenêtre carrée) ou
+	// "bilateral" (moyenne pondérée par la distance et par l'écart de couleur).
+	Method string
+	// Radius est le rayon de la fenêtre de "median" (fenêtre 2*Radius+1).
+	Radius int
+	// SigmaSpace est l'écart type spatial (en pixels) de "bilateral".
+	SigmaSpace float64
+	// SigmaRange est l'écart type sur la couleur de "bilateral", en % de la
+	// dynamique : les voisins plus éloignés en couleur comptent peu, ce qui
+	// évite de flouter les contours.
+	SigmaRange float64
+}
+
+// defaultDenoiseOptions : médiane 5x5.
+var defaultDenoiseOptions = denoiseOptions{
+	Method:     "median",
+	Radius:     2,
+	SigmaSpace: 3,
+	SigmaRange: 10,
+}
+
+// denoiseMethods liste les méthodes acceptées par denoiseOptions.Method.
+var denoiseMethods = []string{"median", "bilateral"}
+
+const (
+	maxMedianRadius    = 100 // coût O(rayon) par pixel
+	maxBilateralSigma  = 10  // fenêtre de côté 4*sigma+1 : coût O(sigma²) par pixel
+	bilateralRangeStep = 16  // les distances de couleur au carré sont tabulées par pas de 2^16
+)
+
+// checkDenoise retourne une erreur si les options sont invalides
+func checkDenoise(opts denoiseOptions) error {
+	switch opts.Method {
+	case "median":
+		if opts.Radius < 1 || opts.Radius > maxMedianRadius {
+			return fmt.Errorf("rayon %d hors de [1, %d]", opts.Radius, maxMedianRadius)
+		}
+		return nil
+	case "bilateral":
+		if opts.SigmaSpace <= 0 || opts.SigmaSpace > maxBilateralSigma {
+			return fmt.Errorf("sigma spatial %v hors de ]0, %d]", opts.SigmaSpace, maxBilateralSigma)
+		}
+		if opts.SigmaRange <= 0 || opts.SigmaRange > 100 {
+			return fmt.Errorf("sigma de couleur %v hors de ]0, 100]", opts.SigmaRange)
+		}
+		return nil
+	}
+	return fmt.Errorf("méthode de débruitage inconnue %q", opts.Method)
+}
+
+// denoisePixels débruite l'image (séquentiel). Retourne un nouveau buffer, ou
+// nil si les options sont invalides.
+func denoisePixels(buf *PixelBuffer, opts denoiseOptions) *PixelBuffer {
+	return denoise(buf, opts, nil)
+}
+
+// denoise est l'implémentation commune : exec nil = séquentiel, sinon les
+// lignes sont réparties par bandes. Chaque pixel ne dépend que de l'entrée :
+// le résultat ne dépend pas du découpage.
+func denoise(buf *PixelBuffer, opts denoiseOptions, exec *Executor) *PixelBuffer {
+	if checkDenoise(opts) != nil {
+		return nil
+	}
+	out := newPixelBuffer(buf.Width, buf.Height)
+	if buf.empty() {
+		return out
+	}
+	if opts.Method == "median" {
+		runRows(exec, buf.Height, func(start, end int) {
+			medianRows(buf, out, opts.Radius, start, end)
+		})
+		return out
+	}
+	bilateral := newBilateralState(buf, out, opts)
+	runRows(exec, buf.Height, bilateral.rows)
+	return out
+}
+
+// channelHistogram est l'histogramme à deux niveaux des valeurs 16 bits d'un
+// canal : 256 cases grossières (octet de poids fort) et 65536 cases fines.
+// Trouver la médiane parcourt au plus 256 + 256 cases.
+type channelHistogram struct {
+	coarse [256]int32
+	fine   [65536]int32
+}
+
+// add ajoute (delta = 1) ou retire (delta = -1) la valeur v
+func (h *channelHistogram) add(v uint16, delta int32) {
+	h.coarse[v>>8] += delta
+	h.fine[v] += delta
+}
+
+// nth retourne la valeur de rang k (0 = la plus petite)
+func (h *channelHistogram) nth(k int32) uint16 {
+	c := 0
+	for ; k >= h.coarse[c]; c++ {
+		k -= h.coarse[c]
+	}
+	v := c << 8
+	for ; k >= h.fine[v]; v++ {
+		k -= h.fine[v]
+	}
+	return uint16(v)
+}
+
+// medianRows applique le filtre médian aux lignes [start, end) (algorithme de
+// Huang) : l'histogramme de la fenêtre est construit une fois par ligne, puis
+// glissé d'une colonne à chaque pixel (une colonne retirée, une ajoutée, soit
+// O(radius) par pixel). Les bords sont répétés.
+func medianRows(src, dst *PixelBuffer, radius, start, end int) {
+	width := src.Width
+	edgeX, edgeY := edgeTable(width, radius, "clamp"), edgeTable(src.Height, radius, "clamp")
+	side := 2*radius + 1
+	mid := int32(side * side / 2)
+	hists := new([3]channelHistogram)
+
+	column := func(x, y int, delta int32) {
+		sx := edgeX[x]
+		for ky := 0; ky < side; ky++ {
+			p := src.Pix[src.offset(sx, edgeY[y+ky])]
+			hists[0].add(p.R, delta)
+			hists[1].add(p.G, delta)
+			hists[2].add(p.B, delta)
+		}
+	}
+
+	for y := start; y < end; y++ {
+		for kx := 0; kx < side; kx++ {
+			column(kx, y, 1)
+		}
+		row := dst.row(y)
+		for x := range row {
+			if x > 0 {
+				column(x-1, y, -1)       // colonne x-1-radius
+				column(x+2*radius, y, 1) // colonne x+radius
+			}
+			row[x] = Pixel{R: hists[0].nth(mid), G: hists[1].nth(mid), B: hists[2].nth(mid)}
+		}
+		// Vide l'histogramme (moins coûteux que de le remettre à zéro)
+		for kx := width - 1; kx < width+2*radius; kx++ {
+			column(kx, y, -1)
+		}
+	}
+}
+
+// bilateralState contient les poids précalculés du filtre bilatéral
+type bilateralState struct {
+	src, dst     *PixelBuffer
+	radius       int
+	edgeX, edgeY []int
+	spatial      []float32 // poids spatial de chaque case de la fenêtre
+	rangeWeight  []float32 // poids selon la distance de couleur au carré >> bilateralRangeStep
+}
+
+// newBilateralState prépare le filtre bilatéral de src vers dst
+func newBilateralState(src, dst *PixelBuffer, opts denoiseOptions) *bilateralState {
+	radius := max(1, int(math.Ceil(2*opts.SigmaSpace)))
+	side := 2*radius + 1
+	s := &bilateralState{
+		src:     src,
+		dst:     dst,
+		radius:  radius,
+		edgeX:   edgeTable(src.Width, radius, "clamp"),
+		edgeY:   edgeTable(src.Height, radius, "clamp"),
+		spatial: make([]float32, side*side),
+	}
+	for ky := 0; ky < side; ky++ {
+		for kx := 0; kx < side; kx++ {
+			dx, dy := float64(kx-radius), float64(ky-radius)
+			s.spatial[ky*side+kx] = float32(math.Exp(-(dx*dx + dy*dy) / (2 * opts.SigmaSpace * opts.SigmaSpace)))
+		}
+	}
+	sigma := opts.SigmaRange / 100 * 65535
+	s.rangeWeight = make([]float32, 3*65535*65535>>bilateralRangeStep+1)
+	for i := range s.rangeWeight {
+		d2 := float64(i) * (1 << bilateralRangeStep)
+		s.rangeWeight[i] = float32(math.Exp(-d2 / (2 * sigma * sigma)))
+	}
+	return s
+}
+
+// rows applique le filtre bilatéral aux lignes [start, end)
+func (s *bilateralState) rows(start, end int) {
+	side := 2*s.radius + 1
+	for y := start; y < end; y++ {
+		row := s.dst.row(y)
+		for x := range row {
+			center := s.src.Pix[s.src.offset(x, y)]
+			var r, g, b, total float32
+			for ky := 0; ky < side; ky++ {
+				in := s.src.row(s.edgeY[y+ky])
+				spatial := s.spatial[ky*side : (ky+1)*side]
+				for kx, ws := range spatial {
+					p := in[s.edgeX[x+kx]]
+					d2 := sqDist(int(p.R), int(p.G), int(p.B), int(center.R), int(center.G), int(center.B))
+					w := ws * s.rangeWeight[d2>>bilateralRangeStep]
+					r += w * float32(p.R)
+					g += w * float32(p.G)
+					b += w * float32(p.B)
+					total += w
+				}
+			}
+			row[x] = Pixel{R: clampChannel(r / total), G: clampChannel(g / total), B: clampChannel(b / total)}
+		}
+	}
+}
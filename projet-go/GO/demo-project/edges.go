@@ -0,0 +1,319 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// edgeOptions décrit une détection de contours. Tous les détecteurs partent
+// du gris de blackWhite (grayPixel, BT.601).
+type edgeOptions struct {
+	// Method : "sobel", "prewitt" (gradient 3x3), "log" (passages par zéro
+	// du laplacien de la gaussienne) ou "canny".
+	Method string
+	// Output : "magnitude" (norme du gradient en gris) ou "direction"
+	// (orientation en teinte, norme en luminosité) pour sobel et prewitt.
+	Output string
+	// Sigma est l'écart type du lissage gaussien de "log" et "canny".
+	Sigma float64
+	// Threshold (en % de la plus forte réponse) est la pente minimale d'un
+	// passage par zéro de "log".
+	Threshold float64
+	// Low et High sont les seuils d'hystérésis de "canny", en % de la plus
+	// forte norme de gradient : un pixel au-dessus de High est un contour,
+	// un pixel au-dessus de Low l'est s'il est relié à un contour.
+	Low, High float64
+}
+
+// defaultEdgeOptions : norme du gradient de Sobel.
+var defaultEdgeOptions = edgeOptions{
+	Method:    "sobel",
+	Output:    "magnitude",
+	Sigma:     1.4,
+	Threshold: 3,
+	Low:       10,
+	High:      25,
+}
+
+// edgeMethods liste les détecteurs acceptés par edgeOptions.Method.
+var edgeMethods = []string{"sobel", "prewitt", "log", "canny"}
+
+// checkEdgeOptions retourne une erreur si les options sont invalides
+func checkEdgeOptions(opts edgeOptions) error {
+	switch opts.Method {
+	case "sobel", "prewitt":
+		if opts.Output != "magnitude" && opts.Output != "direction" {
+			return fmt.Errorf("sortie %q inconnue (magnitude ou direction)", opts.Output)
+		}
+		return nil
+	case "log", "canny":
+		if opts.Sigma <= 0 || math.Ceil(3*opts.Sigma) > maxFilterRadius {
+			return fmt.Errorf("sigma %v hors de ]0, %d]", opts.Sigma, maxFilterRadius/3)
+		}
+		if opts.Method == "log" && (opts.Threshold < 0 || opts.Threshold > 100) {
+			return fmt.Errorf("seuil %v hors de [0, 100]", opts.Threshold)
+		}
+		if opts.Method == "canny" && (opts.Low < 0 || opts.Low > opts.High || opts.High > 100) {
+			return fmt.Errorf("seuils d'hystérésis %v / %v invalides (0 <= low <= high <= 100)", opts.Low, opts.High)
+		}
+		return nil
+	}
+	return fmt.Errorf("détecteur de contours inconnu %q", opts.Method)
+}
+
+// edgePixels calcule la carte des contours (séquentiel) : contours clairs sur
+// fond noir. Retourne nil si les options sont invalides.
+func edgePixels(buf *PixelBuffer, opts edgeOptions) *PixelBuffer {
+	return detectEdges(buf, opts, nil)
+}
+
+// detectEdges est l'implémentation commune : exec nil = séquentiel, sinon
+// chaque étape est répartie par bandes de lignes (seule l'hystérésis de Canny,
+// un parcours de graphe linéaire, reste séquentielle).
+func detectEdges(buf *PixelBuffer, opts edgeOptions, exec *Executor) *PixelBuffer {
+	if checkEdgeOptions(opts) != nil {
+		return nil
+	}
+	width, height := buf.Width, buf.Height
+	out := newPixelBuffer(width, height)
+	if buf.empty() {
+		return out
+	}
+
+	gray16 := grayPlane(buf, defaultGrayOptions, exec)
+	gray := make([]float32, len(gray16))
+	for i, v := range gray16 {
+		gray[i] = float32(v)
+	}
+
+	switch opts.Method {
+	case "sobel", "prewitt":
+		center := float32(2) // Sobel : poids 1, 2, 1 ; Prewitt : 1, 1, 1
+		if opts.Method == "prewitt" {
+			center = 1
+		}
+		gx, gy := gradientPlanes(gray, width, height, center, exec)
+		runRows(exec, height, func(start, end int) {
+			for y := start; y < end; y++ {
+				row := out.row(y)
+				for x := range row {
+					i := y*width + x
+					mag := clampChannel(float32(math.Hypot(float64(gx[i]), float64(gy[i]))))
+					if opts.Output == "direction" {
+						// Une teinte par orientation, à saturation maximale
+						angle := math.Atan2(float64(gy[i]), float64(gx[i])) * 180 / math.Pi
+						row[x] = hsvPixel(angle, 1, float64(mag)/65535)
+					} else {
+						row[x] = Pixel{R: mag, G: mag, B: mag}
+					}
+				}
+			}
+		})
+	case "log":
+		lap := laplacianPlane(blurPlane(gray, width, height, opts.Sigma, exec), width, height, exec)
+		zeroCrossings(lap, out, float32(opts.Threshold/100), exec)
+	case "canny":
+		gx, gy := gradientPlanes(blurPlane(gray, width, height, opts.Sigma, exec), width, height, 2, exec)
+		thin := nonMaxSuppression(gx, gy, width, height, exec)
+		hysteresis(thin, out, float32(opts.Low/100), float32(opts.High/100))
+	}
+	return out
+}
+
+// blurPlane retourne le plan lissé par une gaussienne d'écart type sigma
+// (deux passes 1D, bords répétés)
+func blurPlane(plane []float32, width, height int, sigma float64, exec *Executor) []float32 {
+	weights := gaussianWeights(sigma)
+	r := len(weights) / 2
+	edgeX, edgeY := edgeTable(width, r, "clamp"), edgeTable(height, r, "clamp")
+	tmp := make([]float32, len(plane))
+	out := make([]float32, len(plane))
+	runRows(exec, height, func(start, end int) {
+		for y := start; y < end; y++ {
+			in := plane[y*width : (y+1)*width]
+			for x := 0; x < width; x++ {
+				var s float32
+				for k, w := range weights {
+					s += w * in[edgeX[x+k]]
+				}
+				tmp[y*width+x] = s
+			}
+		}
+	})
+	runRows(exec, height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				var s float32
+				for k, w := range weights {
+					s += w * tmp[edgeY[y+k]*width+x]
+				}
+				out[y*width+x] = s
+			}
+		}
+	})
+	return out
+}
+
+// gradientPlanes retourne les dérivées horizontale et verticale du plan par
+// les noyaux 3x3 [-1 0 1] x [1 center 1] (bords répétés), normalisées pour
+// qu'une marche de 0 à 65535 donne 65535.
+func gradientPlanes(plane []float32, width, height int, center float32, exec *Executor) ([]float32, []float32) {
+	gx := make([]float32, len(plane))
+	gy := make([]float32, len(plane))
+	norm := 1 / (2 + center)
+	at := func(x, y int) float32 {
+		return plane[min(max(y, 0), height-1)*width+min(max(x, 0), width-1)]
+	}
+	runRows(exec, height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				i := y*width + x
+				gx[i] = norm * (at(x+1, y-1) + center*at(x+1, y) + at(x+1, y+1) -
+					at(x-1, y-1) - center*at(x-1, y) - at(x-1, y+1))
+				gy[i] = norm * (at(x-1, y+1) + center*at(x, y+1) + at(x+1, y+1) -
+					at(x-1, y-1) - center*at(x, y-1) - at(x+1, y-1))
+			}
+		}
+	})
+	return gx, gy
+}
+
+// laplacianPlane retourne le laplacien 4 voisins du plan (bords répétés)
+func laplacianPlane(plane []float32, width, height int, exec *Executor) []float32 {
+	lap := make([]float32, len(plane))
+	at := func(x, y int) float32 {
+		return plane[min(max(y, 0), height-1)*width+min(max(x, 0), width-1)]
+	}
+	runRows(exec, height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				lap[y*width+x] = at(x-1, y) + at(x+1, y) + at(x, y-1) + at(x, y+1) - 4*at(x, y)
+			}
+		}
+	})
+	return lap
+}
+
+// zeroCrossings marque en blanc dans out les pixels où le laplacien change de
+// signe avec le voisin de droite ou du dessous, avec une pente d'au moins
+// threshold fois la plus forte réponse |lap|.
+func zeroCrossings(lap []float32, out *PixelBuffer, threshold float32, exec *Executor) {
+	width, height := out.Width, out.Height
+	var peak float32
+	for _, v := range lap {
+		peak = max(peak, v, -v)
+	}
+	minSlope := threshold * peak
+	white := bilevelPixel(true)
+	runRows(exec, height, func(start, end int) {
+		for y := start; y < end; y++ {
+			row := out.row(y)
+			for x := range row {
+				v := lap[y*width+x]
+				for _, n := range [2][2]int{{x + 1, y}, {x, y + 1}} {
+					if n[0] >= width || n[1] >= height {
+						continue
+					}
+					w := lap[n[1]*width+n[0]]
+					if (v < 0) != (w < 0) && max(v-w, w-v) >= minSlope {
+						row[x] = white
+					}
+				}
+			}
+		}
+	})
+}
+
+// nonMaxSuppression retourne la norme du gradient amincie : un pixel n'est
+// gardé que s'il est un maximum local dans la direction du gradient
+// (quantifiée à 0, 45, 90 ou 135 degrés).
+func nonMaxSuppression(gx, gy []float32, width, height int, exec *Executor) []float32 {
+	mag := make([]float32, len(gx))
+	for i := range mag {
+		mag[i] = float32(math.Hypot(float64(gx[i]), float64(gy[i])))
+	}
+	at := func(x, y int) float32 {
+		if x < 0 || x >= width || y < 0 || y >= height {
+			return 0
+		}
+		return mag[y*width+x]
+	}
+	thin := make([]float32, len(mag))
+	runRows(exec, height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x := 0; x < width; x++ {
+				i := y*width + x
+				m := mag[i]
+				if m == 0 {
+					continue
+				}
+				// Angle ramené dans [0, 180[ puis au secteur de 45° le plus proche
+				angle := math.Atan2(float64(gy[i]), float64(gx[i])) * 180 / math.Pi
+				if angle < 0 {
+					angle += 180
+				}
+				var a, b float32
+				switch {
+				case angle < 22.5 || angle >= 157.5:
+					a, b = at(x-1, y), at(x+1, y)
+				case angle < 67.5:
+					a, b = at(x-1, y-1), at(x+1, y+1)
+				case angle < 112.5:
+					a, b = at(x, y-1), at(x, y+1)
+				default:
+					a, b = at(x+1, y-1), at(x-1, y+1)
+				}
+				if m >= a && m > b {
+					thin[i] = m
+				}
+			}
+		}
+	})
+	return thin
+}
+
+// hysteresis écrit dans out les contours de Canny : les pixels de norme
+// >= high*max, et ceux >= low*max reliés (8-connexité) à l'un d'eux.
+func hysteresis(thin []float32, out *PixelBuffer, low, high float32) {
+	width, height := out.Width, out.Height
+	var peak float32
+	for _, v := range thin {
+		peak = max(peak, v)
+	}
+	if peak == 0 {
+		return
+	}
+	lowT, highT := low*peak, high*peak
+
+	edge := make([]bool, len(thin))
+	var stack []int
+	for i, v := range thin {
+		if v >= highT && v > 0 {
+			edge[i] = true
+			stack = append(stack, i)
+		}
+	}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		x, y := i%width, i/width
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				nx, ny := x+dx, y+dy
+				if nx < 0 || nx >= width || ny < 0 || ny >= height {
+					continue
+				}
+				j := ny*width + nx
+				if !edge[j] && thin[j] >= lowT && thin[j] > 0 {
+					edge[j] = true
+					stack = append(stack, j)
+				}
+			}
+		}
+	}
+	for i, e := range edge {
+		if e {
+			out.Pix[out.offset(i%width, i/width)] = bilevelPixel(true)
+		}
+	}
+}
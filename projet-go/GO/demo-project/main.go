@@ -16,8 +16,8 @@ func main() {
 	bounds2 := image2.Bounds()
 	width2, height2 := bounds2.Max.X, bounds2.Max.Y
 
-	rgbMatrix := extractPixels(image, width, height)
-	rgbMatrix2 := extractPixels(image2, width2, height2)
+	buf := extractPixels(image, width, height)
+	buf2 := extractPixels(image2, width2, height2)
 
 	fmt.Printf("Dimensions : %dx%d\n", width, height)
 	fmt.Printf("Nombre de cœurs : %d\n\n", runtime.NumCPU())
@@ -25,23 +25,90 @@ func main() {
 	// ============================================
 	// Test Fonctions (SÉQUENTIEL vs PARALLÈLE)
 	// ============================================
+	CheckExtractFastPath(image)
+	CheckPixelBufferImage(image)
+	CheckDownscaleParallel()
+	CheckConvolveSeparable()
+	CheckMedianFilter()
+	CheckColorSpaces()
+	CheckHilbertCurve()
 	CompareExtractPixels(image)
-	CompareBlackWhite(copyMatrix(rgbMatrix), width, height)
-	CompareDownscalePixels(copyMatrix(rgbMatrix), width, height)
-	CompareRemapPixels(copyMatrix(rgbMatrix), rgbMatrix2, 16)
+	CompareBlackWhite(buf.clone())
+	CompareEdges(buf)
+	CompareGrayscale(buf)
+	CompareBinarize(buf)
+	CompareQuantize(buf)
+	CheckQuantizeStride(buf)
+	ComparePaletteMap(buf, buf2)
+	CompareFilter(buf)
+	CompareDenoise(buf)
+	CompareAdjust(buf)
+	CompareEqualize(buf)
+	CompareMatch(buf, buf2)
+	CompareDownscalePixels(buf.clone())
+	CompareRemapPixels(buf.clone(), buf2, defaultRemapOptions)
+	CheckRemapPlan(buf, buf2)
+	CompareRemapMethods(buf, buf2)
+	CompareRemapRefine(buf, buf2)
+	CompareRemapDistances(buf, buf2)
+	CompareNearestBin(buf, buf2)
+	CompareMatrixBuffer(image, 5)
+	CompareTileScheduler(buf, buf2, 16)
+	CompareResize(buf)
+	CompareUpscale(buf, 4)
 	// ============================================
 	// Traitements
 	// ============================================
-	traitementBW := blackWhite(copyMatrix(rgbMatrix), width, height)
-	traitementDownscale := downscalePixelsParallel(copyMatrix(rgbMatrix), width, height, 2)
-	traitementRemap := remapPixelsParallel(copyMatrix(rgbMatrix), rgbMatrix2, 16)
+	traitementBW := blackWhite(buf.clone())
+	traitementDownscale := downscalePixelsParallel(buf.clone(), 2)
+	traitementDownscaleLinear := downscalePixelsLinearParallel(buf, 8)
+	traitementRemap := remapPixelsParallel(buf.clone(), buf2, defaultRemapOptions)
+	lanczos, _ := kernelByName("lanczos")
+	resizeW, resizeH := fitSize(width, height, 200, 200)
+	traitementResize := resizePixelsParallel(buf, resizeW, resizeH, lanczos)
+	box, _ := kernelByName("box")
+	pixelArt := resizePixels(downscalePixels(buf, 10), width/10, height/10, box)
+	traitementUpscale := upscalePixelsParallel(pixelArt, 8, "scale2x")
+	ditherOpts := defaultBilevelOptions
+	ditherOpts.Method = "floyd-steinberg"
+	traitementDither := bilevelImage(binarizePixelsParallel(buf, ditherOpts))
+	quantizeOpts := defaultQuantizeOptions
+	quantizeOpts.Method = "kmeans"
+	quantizeOpts.Dither = "floyd-steinberg"
+	traitementQuantize := quantizeImageParallel(buf, quantizeOpts)
+	pico8, _ := parsePalette("pico8")
+	paletteOpts := paletteOptions{Palette: pico8, Distance: "redmean", Dither: "atkinson"}
+	traitementPalette := paletteMapImageParallel(buf, paletteOpts)
+	unsharpOpts := defaultFilterOptions
+	unsharpOpts.Filter = "unsharp"
+	traitementUnsharp := filterPixelsParallel(buf, unsharpOpts)
+	cannyOpts := defaultEdgeOptions
+	cannyOpts.Method = "canny"
+	traitementEdges := edgePixelsParallel(buf, cannyOpts)
+	bilateralOpts := defaultDenoiseOptions
+	bilateralOpts.Method = "bilateral"
+	traitementDenoise := denoisePixelsParallel(buf, bilateralOpts)
+	adjustOpts := defaultAdjustOptions
+	adjustOpts.Exposure = 0.5
+	adjustOpts.Contrast = 15
+	traitementAdjust := adjustPixelsParallel(buf.clone(), adjustOpts)
+	traitementEqualize := equalizePixelsParallel(buf.clone(), defaultEqualizeOptions)
+	traitementMatch := matchPixelsParallel(buf, buf2, matchOptions{Method: "reinhard"})
 
-	imageTraitementBW := pixelsToImage(traitementBW)
-	imageTraitementDownscale := pixelsToImage(traitementDownscale)
-	imageTraitementRemap := pixelsToImage(traitementRemap)
-
-	saveImage(imageTraitementBW, "output/blackWhite.jpg")
-	saveImage(imageTraitementDownscale, "output/downscale.jpg")
-	saveImage(imageTraitementRemap, "output/remap.jpg")
+	saveImage(traitementBW, "output/blackWhite.jpg")
+	saveImage(traitementDownscale, "output/downscale.jpg")
+	saveImage(traitementDownscaleLinear, "output/downscaleLinear.jpg")
+	saveImage(traitementRemap, "output/remap.jpg")
+	saveImage(traitementResize, "output/resize.jpg")
+	saveImage(traitementUpscale, "output/upscale.jpg")
+	saveImage(traitementDither, "output/dither.png")
+	saveImage(traitementQuantize, "output/quantize.png")
+	saveImage(traitementPalette, "output/palette.png")
+	saveImage(traitementUnsharp, "output/unsharp.jpg")
+	saveImage(traitementEdges, "output/edges.png")
+	saveImage(traitementDenoise, "output/denoise.jpg")
+	saveImage(traitementAdjust, "output/adjust.jpg")
+	saveImage(traitementEqualize, "output/equalize.jpg")
+	saveImage(traitementMatch, "output/match.jpg")
 	fmt.Println("=== Traitements effectués avec succès ===")
 }
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// matchOptions décrit un transfert de couleurs vers une image cible : au lieu
+// de déplacer les pixels comme remapPixels, on change leurs valeurs pour que
+// leur distribution ressemble à celle de la cible. Le contenu de la source est
+// conservé et la cible peut avoir d'autres dimensions.
+type matchOptions struct {
+	// Method : "histogram" (chaque canal R, G, B reçoit exactement
+	// l'histogramme du canal de la cible, via les fonctions de répartition) ou
+	// "reinhard" (moyenne et écart type transférés dans l'espace décorrélé lαβ
+	// de Reinhard : les canaux sont traités ensemble, sans dérive de teinte).
+	Method string
+}
+
+// defaultMatchOptions : correspondance d'histogrammes par canal.
+var defaultMatchOptions = matchOptions{Method: "histogram"}
+
+// matchMethods liste les méthodes acceptées par matchOptions.Method.
+var matchMethods = []string{"histogram", "reinhard"}
+
+// checkMatch retourne une erreur si les options sont invalides
+func checkMatch(opts matchOptions) error {
+	for _, m := range matchMethods {
+		if opts.Method == m {
+			return nil
+		}
+	}
+	return fmt.Errorf("méthode de transfert inconnue %q", opts.Method)
+}
+
+// matchPixels donne à src la distribution de couleurs de target (séquentiel).
+// Retourne un nouveau buffer de la taille de src, ou nil si les options sont
+// invalides ou si une des images est vide.
+func matchPixels(src, target *PixelBuffer, opts matchOptions) *PixelBuffer {
+	return match(src, target, opts, nil)
+}
+
+// match est l'implémentation commune : exec nil = séquentiel, sinon les
+// statistiques et les pixels sont répartis par bandes. Les statistiques sont
+// cumulées dans un ordre fixe : le résultat ne dépend pas du découpage.
+func match(src, target *PixelBuffer, opts matchOptions, exec *Executor) *PixelBuffer {
+	if checkMatch(opts) != nil || src.empty() || target.empty() {
+		return nil
+	}
+	out := newPixelBuffer(src.Width, src.Height)
+	if opts.Method == "histogram" {
+		luts := matchTables(channelHistograms(src, exec), channelHistograms(target, exec))
+		lutR, lutG, lutB := luts[0], luts[1], luts[2]
+		runRows(exec, src.Height, func(start, end int) {
+			for y := start; y < end; y++ {
+				in, row := src.row(y), out.row(y)
+				for x, p := range in {
+					row[x] = Pixel{R: lutR[p.R], G: lutG[p.G], B: lutB[p.B]}
+				}
+			}
+		})
+		return out
+	}
+
+	srcPlane := lalphabetaPlane(src, exec)
+	srcMean, srcDev := planeStats(srcPlane, src.Width, exec)
+	targetMean, targetDev := planeStats(lalphabetaPlane(target, exec), target.Width, exec)
+	var scale [3]float64
+	for c := range scale {
+		scale[c] = 1
+		if srcDev[c] > 0 {
+			scale[c] = targetDev[c] / srcDev[c]
+		}
+	}
+	runRows(exec, src.Height, func(start, end int) {
+		for y := start; y < end; y++ {
+			row := out.row(y)
+			for x, v := range srcPlane[y*src.Width : (y+1)*src.Width] {
+				var t [3]float64
+				for c := range t {
+					t[c] = (float64(v[c])-srcMean[c])*scale[c] + targetMean[c]
+				}
+				row[x] = lalphabetaPixel(t)
+			}
+		}
+	})
+	return out
+}
+
+// channelHistograms compte les valeurs de chaque canal (R, G, B) de buf
+func channelHistograms(buf *PixelBuffer, exec *Executor) [3][]int {
+	var hists [3][]int
+	for c := range hists {
+		hists[c] = make([]int, 65536)
+	}
+	count := func(h [3][]int, start, end int) {
+		for y := start; y < end; y++ {
+			for _, p := range buf.row(y) {
+				h[0][p.R]++
+				h[1][p.G]++
+				h[2][p.B]++
+			}
+		}
+	}
+	if exec == nil {
+		count(hists, 0, buf.Height)
+		return hists
+	}
+	// Histogrammes partiels par bande, fusionnés ensuite
+	var mu sync.Mutex
+	exec.Rows(buf.Height, func(start, end int) {
+		var local [3][]int
+		for c := range local {
+			local[c] = make([]int, 65536)
+		}
+		count(local, start, end)
+		mu.Lock()
+		for c := range hists {
+			for i, n := range local[c] {
+				hists[c][i] += n
+			}
+		}
+		mu.Unlock()
+	})
+	return hists
+}
+
+// matchTables retourne, pour chaque canal, la table qui envoie chaque valeur
+// de la source sur la plus petite valeur de la cible de même fréquence cumulée
+func matchTables(src, target [3][]int) [3][]uint16 {
+	var luts [3][]uint16
+	for c := range luts {
+		srcTotal, targetTotal := 0, 0
+		for _, n := range src[c] {
+			srcTotal += n
+		}
+		for _, n := range target[c] {
+			targetTotal += n
+		}
+		lut := make([]uint16, 65536)
+		// Les deux fonctions de répartition sont croissantes : un seul
+		// parcours de la cible suffit. Les fréquences sont comparées en
+		// produits croisés pour rester en entiers.
+		srcCdf, targetCdf, u := 0, target[c][0], 0
+		for v, n := range src[c] {
+			srcCdf += n
+			for u < 65535 && targetCdf*srcTotal < srcCdf*targetTotal {
+				u++
+				targetCdf += target[c][u]
+			}
+			lut[v] = uint16(u)
+		}
+		luts[c] = lut
+	}
+	return luts
+}
+
+// Constantes de l'espace lαβ de Reinhard et al. (2001) : RGB linéaire -> LMS
+// (cônes de l'œil), logarithme, puis rotation qui décorrèle les trois axes
+var (
+	rgbToLMS = [3][3]float64{
+		{0.3811, 0.5783, 0.0402},
+		{0.1967, 0.7244, 0.0782},
+		{0.0241, 0.1288, 0.8444},
+	}
+	lmsToRGB = [3][3]float64{
+		{4.4679, -3.5873, 0.1193},
+		{-1.2186, 2.3809, -0.1624},
+		{0.0497, -0.2439, 1.2045},
+	}
+	invSqrt2 = 1 / math.Sqrt2
+	invSqrt3 = 1 / math.Sqrt(3)
+	invSqrt6 = 1 / math.Sqrt(6)
+)
+
+// minLMS évite le logarithme de zéro sur les pixels noirs
+const minLMS = 1.0 / 65535
+
+// lalphabeta convertit un pixel sRGB en coordonnées lαβ
+func lalphabeta(p Pixel) [3]float32 {
+	var lms [3]float64
+	r, g, b := p.linearRGB()
+	lms[0], lms[1], lms[2] = mulMatrix(rgbToLMS, r, g, b)
+	for i := range lms {
+		lms[i] = math.Log10(max(minLMS, lms[i]))
+	}
+	return [3]float32{
+		float32((lms[0] + lms[1] + lms[2]) * invSqrt3),
+		float32((lms[0] + lms[1] - 2*lms[2]) * invSqrt6),
+		float32((lms[0] - lms[1]) * invSqrt2),
+	}
+}
+
+// lalphabetaPixel convertit des coordonnées lαβ en pixel sRGB (saturé si hors gamut)
+func lalphabetaPixel(lab [3]float64) Pixel {
+	l, a, b := lab[0]*invSqrt3, lab[1]*invSqrt6, lab[2]*invSqrt2
+	return linearPixel(mulMatrix(lmsToRGB, math.Pow(10, l+a+b), math.Pow(10, l+a-b), math.Pow(10, l-2*a)))
+}
+
+// lalphabetaPlane convertit buf en plan lαβ (width*height entrées, sans stride)
+func lalphabetaPlane(buf *PixelBuffer, exec *Executor) [][3]float32 {
+	plane := make([][3]float32, buf.Width*buf.Height)
+	runRows(exec, buf.Height, func(start, end int) {
+		for y := start; y < end; y++ {
+			out := plane[y*buf.Width : (y+1)*buf.Width]
+			for x, p := range buf.row(y) {
+				out[x] = lalphabeta(p)
+			}
+		}
+	})
+	return plane
+}
+
+// planeStats retourne la moyenne et l'écart type de chaque axe du plan. Les
+// sommes sont faites par ligne puis cumulées dans l'ordre des lignes.
+func planeStats(plane [][3]float32, width int, exec *Executor) (mean, dev [3]float64) {
+	height := len(plane) / width
+	rowSums := make([][6]float64, height)
+	runRows(exec, height, func(start, end int) {
+		for y := start; y < end; y++ {
+			s := &rowSums[y]
+			for _, v := range plane[y*width : (y+1)*width] {
+				for c := 0; c < 3; c++ {
+					s[c] += float64(v[c])
+					s[3+c] += float64(v[c]) * float64(v[c])
+				}
+			}
+		}
+	})
+	var sum [6]float64
+	for _, s := range rowSums {
+		for i := range sum {
+			sum[i] += s[i]
+		}
+	}
+	n := float64(len(plane))
+	for c := range mean {
+		mean[c] = sum[c] / n
+		dev[c] = math.Sqrt(max(0, sum[3+c]/n-mean[c]*mean[c]))
+	}
+	return mean, dev
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// paletteOptions décrit la projection d'une image sur une palette imposée.
+type paletteOptions struct {
+	// Palette est la liste des couleurs autorisées (1 à 256).
+	Palette []Pixel
+	// Distance est une clé de colorMetrics : "rgb" (sqDist), "redmean"
+	// (distance RGB pondérée selon le rouge moyen), "cie76" ou "ciede2000"
+	// (écarts perceptuels en Lab, plus fidèles mais plus coûteux).
+	Distance string
+	// Dither : "none", ou un noyau de diffusion d'erreur de diffusionKernels.
+	Dither string
+}
+
+// maxPaletteColors est la taille maximale d'une palette (limite de image.Paletted en PNG/GIF)
+const maxPaletteColors = 256
+
+// namedPalettes contient quelques palettes rétro prêtes à l'emploi
+var namedPalettes = map[string]string{
+	"gameboy": "0f380f,306230,8bac0f,9bbc0f",
+	"cga": "000000,0000aa,00aa00,00aaaa,aa0000,aa00aa,aa5500,aaaaaa," +
+		"555555,5555ff,55ff55,55ffff,ff5555,ff55ff,ffff55,ffffff",
+	"pico8": "000000,1d2b53,7e2553,008751,ab5236,5f574f,c2c3c7,fff1e8," +
+		"ff004d,ffa300,ffec27,00e436,29adff,83769c,ff77a8,ffccaa",
+}
+
+// parsePalette lit une palette : un nom de namedPalettes, ou une liste de
+// couleurs hexadécimales séparées par des virgules ("#ff0000,00ff00,#00f").
+func parsePalette(spec string) ([]Pixel, error) {
+	if named, ok := namedPalettes[spec]; ok {
+		spec = named
+	}
+	var palette []Pixel
+	for _, field := range strings.Split(spec, ",") {
+		hex := strings.TrimPrefix(strings.TrimSpace(field), "#")
+		if len(hex) == 3 {
+			hex = string([]byte{hex[0], hex[0], hex[1], hex[1], hex[2], hex[2]})
+		}
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if len(hex) != 6 || err != nil {
+			return nil, fmt.Errorf("couleur %q invalide (attendu #rrggbb ou #rgb)", field)
+		}
+		// 8 bits -> 16 bits : x * 257 (0xff -> 0xffff)
+		palette = append(palette, Pixel{
+			R: uint16(v>>16) * 257,
+			G: uint16(v>>8&0xff) * 257,
+			B: uint16(v&0xff) * 257,
+		})
+	}
+	if len(palette) > maxPaletteColors {
+		return nil, fmt.Errorf("palette de %d couleurs (max %d)", len(palette), maxPaletteColors)
+	}
+	return palette, nil
+}
+
+// checkPaletteMap retourne une erreur si les options sont invalides
+func checkPaletteMap(opts paletteOptions) error {
+	if len(opts.Palette) == 0 || len(opts.Palette) > maxPaletteColors {
+		return fmt.Errorf("palette de %d couleurs (1 à %d)", len(opts.Palette), maxPaletteColors)
+	}
+	if _, ok := colorMetrics[opts.Distance]; !ok {
+		return fmt.Errorf("distance inconnue %q", opts.Distance)
+	}
+	return checkDither(opts.Dither)
+}
+
+// paletteMapImage remplace chaque pixel par la couleur la plus proche de la
+// palette imposée (séquentiel). Retourne nil si les options sont invalides.
+func paletteMapImage(buf *PixelBuffer, opts paletteOptions) *image.Paletted {
+	return paletteMap(buf, opts, nil)
+}
+
+// paletteMap est l'implémentation commune : exec nil = séquentiel, sinon les
+// lignes (ou le front d'onde du tramage) sont réparties par exec
+func paletteMap(buf *PixelBuffer, opts paletteOptions, exec *Executor) *image.Paletted {
+	if checkPaletteMap(opts) != nil {
+		return nil
+	}
+	metric, palette := colorMetrics[opts.Distance], opts.Palette
+	coords := make([][3]float64, len(palette))
+	for i, c := range palette {
+		coords[i] = metric.coords(c)
+	}
+	nearest := func(p Pixel) int {
+		pc := metric.coords(p)
+		best, bestDist := 0, math.Inf(1)
+		for i, c := range coords {
+			if d := metric.dist(pc, c); d < bestDist {
+				best, bestDist = i, d
+			}
+		}
+		return best
+	}
+	return mapToPalette(buf, palette, nearest, opts.Dither, exec)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+)
+
+// refineTileSize est le côté (en pixels) des tuiles de l'affinage du remap :
+// un pixel n'est échangé qu'avec un pixel de sa tuile.
+const refineTileSize = 64
+
+// maxRefinePasses et maxRefineSigma bornent les options d'affinage ; le flou
+// de la cible doit rester accepté par le filtre gaussien (3*sigma <= rayon max)
+const (
+	maxRefinePasses = 100
+	maxRefineSigma  = min(20, maxFilterRadius/3)
+)
+
+// refineTargetWeight est le poids de l'écart à la cible dans le coût d'un
+// pixel, face aux écarts à ses (au plus) quatre voisins, de poids 1 chacun.
+const refineTargetWeight = 4
+
+// checkRefine retourne une erreur si les options d'affinage sont invalides
+func checkRefine(passes int, sigma float64) error {
+	if passes < 0 || passes > maxRefinePasses {
+		return fmt.Errorf("passes d'affinage %d hors de [0, %d]", passes, maxRefinePasses)
+	}
+	if math.IsNaN(sigma) || sigma < 0 || sigma > maxRefineSigma {
+		return fmt.Errorf("sigma d'affinage %v hors de [0, %d]", sigma, maxRefineSigma)
+	}
+	return nil
+}
+
+// refineRemap affine un résultat de remap (in-place) : à chaque passe, chaque
+// pixel est comparé à un pixel tiré au hasard dans sa tuile, et les deux sont
+// échangés si l'échange diminue la somme de leurs coûts. Le coût d'un pixel
+// est son écart (RGB, au carré) à la cible, pondéré par refineTargetWeight,
+// plus ses écarts à ses voisins : le résultat reste une permutation de la
+// source, mais les pixels se regroupent là où la cible leur ressemble, à côté
+// de pixels proches d'eux, et le grain diminue (CompareRemapRefine).
+//
+// Avec opts.RefineSigma > 0, la référence est la cible floutée (gaussienne de
+// cet écart type) : les échanges suivent les formes de la cible plutôt que
+// son bruit et ses textures fines.
+//
+// Les tuiles sont indépendantes et traitées en parallèle par exec : seuls les
+// voisins de la même tuile comptent. La grille est décalée d'une demi-tuile
+// une passe sur deux, pour que les pixels puissent passer d'une tuile à
+// l'autre et que les bords de tuiles changent. Chaque tuile tire ses
+// partenaires d'un générateur initialisé par opts.Seed, la passe et l'index
+// de la tuile : le résultat ne dépend pas de exec.
+func refineRemap(out, target *PixelBuffer, opts remapOptions, exec *Executor) *PixelBuffer {
+	if opts.RefinePasses == 0 {
+		return out
+	}
+	ref := target
+	if opts.RefineSigma > 0 {
+		blur := defaultFilterOptions
+		blur.Filter, blur.Sigma = "gaussian", opts.RefineSigma
+		ref = filter(target, blur, exec)
+	}
+	width, height := out.Width, out.Height
+	dist := func(p, q Pixel) int {
+		return sqDist(int(p.R), int(p.G), int(p.B), int(q.R), int(q.G), int(q.B))
+	}
+
+	for pass := range opts.RefinePasses {
+		shift := pass % 2 * refineTileSize / 2
+		tilesX := (width + shift + refineTileSize - 1) / refineTileSize
+		tilesY := (height + shift + refineTileSize - 1) / refineTileSize
+		runRows(exec, tilesX*tilesY, func(start, end int) {
+			source := rand.NewPCG(0, 0)
+			rng := rand.New(source)
+			var x0, y0, x1, y1 int
+			// cost est le coût du pixel p placé en (x, y) : son écart à la
+			// référence, pondéré, plus ses écarts aux voisins de la tuile, sauf
+			// le partenaire (ox, oy), dont l'écart ne change pas à l'échange
+			cost := func(p Pixel, x, y, ox, oy int) int {
+				c := refineTargetWeight * dist(p, ref.Pix[ref.offset(x, y)])
+				for _, d := range [4][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+					nx, ny := x+d[0], y+d[1]
+					if nx < x0 || nx >= x1 || ny < y0 || ny >= y1 || nx == ox && ny == oy {
+						continue
+					}
+					c += dist(p, out.Pix[out.offset(nx, ny)])
+				}
+				return c
+			}
+			for t := start; t < end; t++ {
+				source.Seed(uint64(opts.Seed), uint64(pass)<<32|uint64(t))
+				x0 = max(0, t%tilesX*refineTileSize-shift)
+				y0 = max(0, t/tilesX*refineTileSize-shift)
+				x1 = min(width, (t%tilesX+1)*refineTileSize-shift)
+				y1 = min(height, (t/tilesX+1)*refineTileSize-shift)
+				w, size := x1-x0, (x1-x0)*(y1-y0)
+				for i := range size {
+					j := rng.IntN(size)
+					if i == j {
+						continue
+					}
+					ax, ay := x0+i%w, y0+i/w
+					bx, by := x0+j%w, y0+j/w
+					a, b := &out.Pix[out.offset(ax, ay)], &out.Pix[out.offset(bx, by)]
+					if cost(*b, ax, ay, bx, by)+cost(*a, bx, by, ax, ay) < cost(*a, ax, ay, bx, by)+cost(*b, bx, by, ax, ay) {
+						*a, *b = *b, *a
+					}
+				}
+			}
+		})
+	}
+	return out
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+)
+
+// upscaleMethods liste les méthodes d'agrandissement (paramètre "method").
+// nearest, bilinear et bicubic reprennent les noyaux de resize.go ; scale2x
+// (EPX) et scale3x sont des agrandisseurs pour pixel art qui gardent les
+// contours nets et arrondissent les diagonales au lieu de flouter.
+var upscaleMethods = []string{"nearest", "bilinear", "bicubic", "scale2x", "scale3x"}
+
+// checkUpscale retourne une erreur si la méthode est inconnue ou ne sait pas
+// agrandir du facteur demandé (scale2x : 2, 4, 8... ; scale3x : 3, 9, 27...).
+func checkUpscale(method string, factor int) error {
+	if factor < 1 {
+		return fmt.Errorf("facteur %d invalide", factor)
+	}
+	switch method {
+	case "nearest", "bilinear", "bicubic":
+		return nil
+	case "scale2x":
+		if !isPowerOf(factor, 2) {
+			return fmt.Errorf("scale2x n'accepte que les puissances de 2 (reçu %d)", factor)
+		}
+		return nil
+	case "scale3x":
+		if !isPowerOf(factor, 3) {
+			return fmt.Errorf("scale3x n'accepte que les puissances de 3 (reçu %d)", factor)
+		}
+		return nil
+	}
+	return fmt.Errorf("méthode d'agrandissement inconnue %q", method)
+}
+
+// isPowerOf indique si n est une puissance de base (base^0 = 1 compris)
+func isPowerOf(n, base int) bool {
+	for n > 1 && n%base == 0 {
+		n /= base
+	}
+	return n == 1
+}
+
+// upscalePixels agrandit l'image d'un facteur entier (séquentiel).
+// Retourne nil si checkUpscale refuse la méthode ou le facteur.
+func upscalePixels(buf *PixelBuffer, factor int, method string) *PixelBuffer {
+	if checkUpscale(method, factor) != nil {
+		return nil
+	}
+	switch method {
+	case "scale2x":
+		for ; factor > 1; factor /= 2 {
+			dst := newPixelBuffer(2*buf.Width, 2*buf.Height)
+			scale2xRows(buf, dst, 0, buf.Height)
+			buf = dst
+		}
+		return buf
+	case "scale3x":
+		for ; factor > 1; factor /= 3 {
+			dst := newPixelBuffer(3*buf.Width, 3*buf.Height)
+			scale3xRows(buf, dst, 0, buf.Height)
+			buf = dst
+		}
+		return buf
+	}
+	k, _ := kernelByName(method)
+	return resizePixels(buf, factor*buf.Width, factor*buf.Height, k)
+}
+
+// neighbor retourne le pixel (x, y), coordonnées ramenées dans l'image
+func (b *PixelBuffer) neighbor(x, y int) Pixel {
+	x = min(max(x, 0), b.Width-1)
+	y = min(max(y, 0), b.Height-1)
+	return b.Pix[b.offset(x, y)]
+}
+
+// scale2xRows applique Scale2x (EPX) aux lignes source [start, end) : chaque
+// pixel P devient un bloc 2x2 dont chaque coin prend la couleur des deux voisins
+// qui s'y rejoignent quand ils sont égaux (diagonale), sinon P.
+//
+//	  A          E0 E1
+//	C P B   ->   E2 E3
+//	  D
+func scale2xRows(src, dst *PixelBuffer, start, end int) {
+	for y := start; y < end; y++ {
+		top, bottom := dst.row(2*y), dst.row(2*y+1)
+		for x := 0; x < src.Width; x++ {
+			p := src.neighbor(x, y)
+			a := src.neighbor(x, y-1)
+			b := src.neighbor(x+1, y)
+			c := src.neighbor(x-1, y)
+			d := src.neighbor(x, y+1)
+
+			e0, e1, e2, e3 := p, p, p, p
+			if c == a && c != d && a != b {
+				e0 = a
+			}
+			if a == b && a != c && b != d {
+				e1 = b
+			}
+			if d == c && d != b && c != a {
+				e2 = c
+			}
+			if b == d && b != a && d != c {
+				e3 = d
+			}
+			top[2*x], top[2*x+1] = e0, e1
+			bottom[2*x], bottom[2*x+1] = e2, e3
+		}
+	}
+}
+
+// scale3xRows applique Scale3x aux lignes source [start, end) : chaque pixel E
+// devient un bloc 3x3 selon ses 8 voisins.
+//
+//	A B C        E0 E1 E2
+//	D E F   ->   E3 E4 E5
+//	G H I        E6 E7 E8
+func scale3xRows(src, dst *PixelBuffer, start, end int) {
+	for y := start; y < end; y++ {
+		r0, r1, r2 := dst.row(3*y), dst.row(3*y+1), dst.row(3*y+2)
+		for x := 0; x < src.Width; x++ {
+			a, b, c := src.neighbor(x-1, y-1), src.neighbor(x, y-1), src.neighbor(x+1, y-1)
+			d, e, f := src.neighbor(x-1, y), src.neighbor(x, y), src.neighbor(x+1, y)
+			g, h, i := src.neighbor(x-1, y+1), src.neighbor(x, y+1), src.neighbor(x+1, y+1)
+
+			out := [9]Pixel{e, e, e, e, e, e, e, e, e}
+			if b != h && d != f {
+				if d == b {
+					out[0] = d
+				}
+				if (d == b && e != c) || (b == f && e != a) {
+					out[1] = b
+				}
+				if b == f {
+					out[2] = f
+				}
+				if (d == b && e != g) || (d == h && e != a) {
+					out[3] = d
+				}
+				if (b == f && e != i) || (h == f && e != c) {
+					out[5] = f
+				}
+				if d == h {
+					out[6] = d
+				}
+				if (d == h && e != i) || (h == f && e != g) {
+					out[7] = h
+				}
+				if h == f {
+					out[8] = f
+				}
+			}
+			copy(r0[3*x:3*x+3], out[0:3])
+			copy(r1[3*x:3*x+3], out[3:6])
+			copy(r2[3*x:3*x+3], out[6:9])
+		}
+	}
+}
@@ -0,0 +1,507 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"math/rand/v2"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// bilevelOptions décrit une conversion en vrai noir et blanc (1 bit).
+// L'image est d'abord passée en niveaux de gris (comme blackWhite, ou selon
+// Gray), puis chaque pixel devient noir (0) ou blanc (65535).
+type bilevelOptions struct {
+	// Method : seuillage "fixed", "otsu" (seuil automatique), "adaptive"
+	// (moyenne locale), ou tramage "floyd-steinberg", "atkinson", "bayer"
+	// (ordonné), "bluenoise".
+	Method string
+	// Threshold est le seuil de "fixed", en % de blanc (50 par défaut).
+	Threshold float64
+	// Radius est le rayon de la fenêtre de "adaptive" (fenêtre 2*Radius+1).
+	Radius int
+	// Offset (en %) est retiré de la moyenne locale de "adaptive" : un pixel
+	// n'est noir que s'il est nettement plus sombre que son voisinage.
+	Offset float64
+	// BayerSize est la taille de la matrice de "bayer" : 2, 4 ou 8.
+	BayerSize int
+	// Gray est la conversion en gris appliquée avant binarisation.
+	Gray grayOptions
+}
+
+// defaultBilevelOptions : seuil fixe à 50 % sur le gris de blackWhite.
+var defaultBilevelOptions = bilevelOptions{
+	Method:    "fixed",
+	Threshold: 50,
+	Radius:    15,
+	Offset:    2,
+	BayerSize: 4,
+	Gray:      defaultGrayOptions,
+}
+
+// bilevelMethods liste les méthodes acceptées par bilevelOptions.Method.
+var bilevelMethods = []string{"fixed", "otsu", "adaptive", "floyd-steinberg", "atkinson", "bayer", "bluenoise"}
+
+// checkBilevel retourne une erreur si les options sont invalides
+func checkBilevel(opts bilevelOptions) error {
+	if _, err := grayConverter(opts.Gray); err != nil {
+		return err
+	}
+	switch opts.Method {
+	case "fixed":
+		if opts.Threshold < 0 || opts.Threshold > 100 {
+			return fmt.Errorf("seuil %v hors de [0, 100]", opts.Threshold)
+		}
+	case "adaptive":
+		if opts.Radius < 1 {
+			return fmt.Errorf("rayon %d invalide (>= 1)", opts.Radius)
+		}
+	case "bayer":
+		if opts.BayerSize != 2 && opts.BayerSize != 4 && opts.BayerSize != 8 {
+			return fmt.Errorf("taille de matrice de Bayer %d invalide (2, 4 ou 8)", opts.BayerSize)
+		}
+	case "otsu", "floyd-steinberg", "atkinson", "bluenoise":
+	default:
+		return fmt.Errorf("méthode de binarisation inconnue %q", opts.Method)
+	}
+	return nil
+}
+
+// grayPlane retourne le niveau de gris de chaque pixel (un seul canal)
+func grayPlane(buf *PixelBuffer, opts grayOptions, exec *Executor) []uint16 {
+	convert, _ := grayConverter(opts)
+	plane := make([]uint16, buf.Width*buf.Height)
+	rows := func(start, end int) {
+		for y := start; y < end; y++ {
+			out := plane[y*buf.Width : (y+1)*buf.Width]
+			for x, p := range buf.row(y) {
+				out[x] = convert(p).R
+			}
+		}
+	}
+	runRows(exec, buf.Height, rows)
+	return plane
+}
+
+// bilevelPixel retourne le pixel noir ou blanc
+func bilevelPixel(white bool) Pixel {
+	if white {
+		return Pixel{R: 65535, G: 65535, B: 65535}
+	}
+	return Pixel{}
+}
+
+// binarizePixels convertit l'image en noir et blanc 1 bit (séquentiel).
+// Retourne un nouveau buffer ne contenant que des pixels noirs ou blancs,
+// ou nil si les options sont invalides.
+func binarizePixels(buf *PixelBuffer, opts bilevelOptions) *PixelBuffer {
+	return binarize(buf, opts, nil)
+}
+
+// binarize est l'implémentation commune : exec nil = séquentiel, sinon les
+// étapes sont réparties par exec
+func binarize(buf *PixelBuffer, opts bilevelOptions, exec *Executor) *PixelBuffer {
+	if checkBilevel(opts) != nil {
+		return nil
+	}
+	width, height := buf.Width, buf.Height
+	gray := grayPlane(buf, opts.Gray, exec)
+	out := newPixelBuffer(width, height)
+
+	// Seuil par pixel : white(x, y, v) décide de la couleur du pixel de gris v
+	var white func(x, y int, v uint16) bool
+	switch opts.Method {
+	case "fixed":
+		t := uint16(math.Round(opts.Threshold / 100 * 65535))
+		white = func(x, y int, v uint16) bool { return v >= t }
+	case "otsu":
+		t := otsuThreshold(gray, exec)
+		white = func(x, y int, v uint16) bool { return v > t }
+	case "adaptive":
+		integral := integralImage(gray, width, height, exec)
+		r := opts.Radius
+		offset := opts.Offset / 100 * 65535
+		white = func(x, y int, v uint16) bool {
+			x0, y0 := max(x-r, 0), max(y-r, 0)
+			x1, y1 := min(x+r+1, width), min(y+r+1, height)
+			w := width + 1
+			sum := integral[y1*w+x1] - integral[y0*w+x1] - integral[y1*w+x0] + integral[y0*w+x0]
+			mean := float64(sum) / float64((x1-x0)*(y1-y0))
+			return float64(v) >= mean-offset
+		}
+	case "bayer":
+		n := opts.BayerSize
+		matrix := bayerThresholds(n)
+		white = func(x, y int, v uint16) bool { return v > matrix[(y%n)*n+x%n] }
+	case "bluenoise":
+		mask := blueNoiseThresholds()
+		white = func(x, y int, v uint16) bool {
+			return v > mask[(y%blueNoiseSize)*blueNoiseSize+x%blueNoiseSize]
+		}
+	case "floyd-steinberg", "atkinson":
+		diffuseError(gray, out, diffusionKernels[opts.Method], exec)
+		return out
+	}
+
+	rows := func(start, end int) {
+		for y := start; y < end; y++ {
+			row := out.row(y)
+			in := gray[y*width : (y+1)*width]
+			for x, v := range in {
+				row[x] = bilevelPixel(white(x, y, v))
+			}
+		}
+	}
+	runRows(exec, height, rows)
+	return out
+}
+
+// otsuThreshold calcule le seuil d'Otsu (maximisation de la variance
+// inter-classes) sur un histogramme 256 niveaux, ramené sur 16 bits.
+func otsuThreshold(gray []uint16, exec *Executor) uint16 {
+	var hist [256]int
+	if exec == nil {
+		for _, v := range gray {
+			hist[v>>8]++
+		}
+	} else {
+		// Histogrammes partiels par bande, fusionnés ensuite
+		var mu sync.Mutex
+		exec.Rows(len(gray), func(start, end int) {
+			var local [256]int
+			for _, v := range gray[start:end] {
+				local[v>>8]++
+			}
+			mu.Lock()
+			for i, c := range local {
+				hist[i] += c
+			}
+			mu.Unlock()
+		})
+	}
+
+	total := len(gray)
+	sumAll := 0.0
+	for i, c := range hist {
+		sumAll += float64(i * c)
+	}
+	best, bestVar := 0, -1.0
+	countBg, sumBg := 0, 0.0
+	for t := 0; t < 256; t++ {
+		countBg += hist[t]
+		sumBg += float64(t * hist[t])
+		countFg := total - countBg
+		if countBg == 0 || countFg == 0 {
+			continue
+		}
+		meanBg := sumBg / float64(countBg)
+		meanFg := (sumAll - sumBg) / float64(countFg)
+		between := float64(countBg) * float64(countFg) * (meanBg - meanFg) * (meanBg - meanFg)
+		if between > bestVar {
+			best, bestVar = t, between
+		}
+	}
+	// Niveau 8 bits t -> plus grande valeur 16 bits de ce niveau
+	return uint16(best<<8 | 0xff)
+}
+
+// integralImage retourne l'image intégrale (width+1) x (height+1) du plan de
+// gris : integral[y*(width+1)+x] est la somme des pixels de [0, x) x [0, y).
+func integralImage(gray []uint16, width, height int, exec *Executor) []uint64 {
+	w := width + 1
+	integral := make([]uint64, w*(height+1))
+	// Passe 1 : sommes cumulées sur chaque ligne
+	rowSums := func(start, end int) {
+		for y := start; y < end; y++ {
+			var acc uint64
+			line := integral[(y+1)*w : (y+2)*w]
+			for x, v := range gray[y*width : (y+1)*width] {
+				acc += uint64(v)
+				line[x+1] = acc
+			}
+		}
+	}
+	// Passe 2 : cumul vertical, colonne par colonne
+	colSums := func(start, end int) {
+		for y := 1; y <= height; y++ {
+			line, prev := integral[y*w:(y+1)*w], integral[(y-1)*w:y*w]
+			for x := start + 1; x <= end; x++ {
+				line[x] += prev[x]
+			}
+		}
+	}
+	runRows(exec, height, rowSums)
+	runRows(exec, width, colSums)
+	return integral
+}
+
+// bayerThresholds retourne la matrice de Bayer n x n sous forme de seuils 16 bits
+func bayerThresholds(n int) []uint16 {
+	index := []int{0}
+	for size := 1; size < n; size *= 2 {
+		next := make([]int, 4*size*size)
+		for y := 0; y < size; y++ {
+			for x := 0; x < size; x++ {
+				v := 4 * index[y*size+x]
+				next[y*2*size+x] = v
+				next[y*2*size+x+size] = v + 2
+				next[(y+size)*2*size+x] = v + 3
+				next[(y+size)*2*size+x+size] = v + 1
+			}
+		}
+		index = next
+	}
+	thresholds := make([]uint16, n*n)
+	for i, v := range index {
+		thresholds[i] = uint16((float64(v) + 0.5) / float64(n*n) * 65535)
+	}
+	return thresholds
+}
+
+// blueNoiseSize est le côté du masque de bruit bleu (répété en mosaïque)
+const blueNoiseSize = 64
+
+var (
+	blueNoiseOnce sync.Once
+	blueNoiseMask []uint16
+)
+
+// blueNoiseThresholds retourne le masque de bruit bleu sous forme de seuils
+// 16 bits, généré une seule fois par l'algorithme void-and-cluster
+// (Ulichney 1993) avec une graine fixe : le résultat est reproductible.
+func blueNoiseThresholds() []uint16 {
+	blueNoiseOnce.Do(func() {
+		ranks := voidAndCluster(blueNoiseSize, 1.5, rand.New(rand.NewPCG(1, 0)))
+		n := len(ranks)
+		blueNoiseMask = make([]uint16, n)
+		for i, r := range ranks {
+			blueNoiseMask[i] = uint16((float64(r) + 0.5) / float64(n) * 65535)
+		}
+	})
+	return blueNoiseMask
+}
+
+// voidAndCluster retourne le rang (0..size²-1) de chaque cellule d'un masque
+// de bruit bleu torique size x size.
+func voidAndCluster(size int, sigma float64, rng *rand.Rand) []int {
+	n := size * size
+	// Noyau gaussien torique : kernel[dy*size+dx]
+	kernel := make([]float64, n)
+	for dy := 0; dy < size; dy++ {
+		for dx := 0; dx < size; dx++ {
+			ddx := float64(min(dx, size-dx))
+			ddy := float64(min(dy, size-dy))
+			kernel[dy*size+dx] = math.Exp(-(ddx*ddx + ddy*ddy) / (2 * sigma * sigma))
+		}
+	}
+
+	pattern := make([]bool, n)
+	energy := make([]float64, n)
+	update := func(i int, sign float64) {
+		ix, iy := i%size, i/size
+		for y := 0; y < size; y++ {
+			dy := (y - iy + size) % size
+			for x := 0; x < size; x++ {
+				dx := (x - ix + size) % size
+				energy[y*size+x] += sign * kernel[dy*size+dx]
+			}
+		}
+	}
+	// tightestCluster : point posé d'énergie maximale ; largestVoid : case
+	// vide d'énergie minimale
+	tightestCluster := func() int {
+		best := -1
+		for i := range pattern {
+			if pattern[i] && (best < 0 || energy[i] > energy[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+	largestVoid := func() int {
+		best := -1
+		for i := range pattern {
+			if !pattern[i] && (best < 0 || energy[i] < energy[best]) {
+				best = i
+			}
+		}
+		return best
+	}
+
+	// Motif initial : ~10 % de points aléatoires, puis redistribution jusqu'à
+	// ce que le point le plus serré soit aussi le meilleur trou.
+	ones := n / 10
+	for _, i := range rng.Perm(n)[:ones] {
+		pattern[i] = true
+		update(i, 1)
+	}
+	for {
+		c := tightestCluster()
+		pattern[c] = false
+		update(c, -1)
+		v := largestVoid()
+		pattern[v] = true
+		update(v, 1)
+		if v == c {
+			break
+		}
+	}
+
+	ranks := make([]int, n)
+	initial := append([]bool{}, pattern...)
+	initialEnergy := append([]float64{}, energy...)
+
+	// Phase 1 : retirer les points du motif initial, du plus serré au moins serré
+	for rank := ones - 1; rank >= 0; rank-- {
+		c := tightestCluster()
+		pattern[c] = false
+		update(c, -1)
+		ranks[c] = rank
+	}
+	// Phases 2 et 3 : repartir du motif initial et remplir les plus grands trous
+	copy(pattern, initial)
+	copy(energy, initialEnergy)
+	for rank := ones; rank < n; rank++ {
+		v := largestVoid()
+		pattern[v] = true
+		update(v, 1)
+		ranks[v] = rank
+	}
+	return ranks
+}
+
+// diffusionKernel décrit un tramage par diffusion d'erreur : l'erreur de
+// quantification d'un pixel est répartie sur ses voisins pas encore traités,
+// chaque part valant err*weight/divisor.
+type diffusionKernel struct {
+	taps    []diffusionTap
+	divisor int32
+}
+
+type diffusionTap struct {
+	dx, dy int
+	weight int32
+}
+
+// diffusionKernels liste les noyaux de diffusion disponibles
+var diffusionKernels = map[string]diffusionKernel{
+	"floyd-steinberg": {divisor: 16, taps: []diffusionTap{
+		{1, 0, 7}, {-1, 1, 3}, {0, 1, 5}, {1, 1, 1},
+	}},
+	// Atkinson ne diffuse que 6/8 de l'erreur : contraste plus marqué
+	"atkinson": {divisor: 8, taps: []diffusionTap{
+		{1, 0, 1}, {2, 0, 1}, {-1, 1, 1}, {0, 1, 1}, {1, 1, 1}, {0, 2, 1},
+	}},
+}
+
+// diffusionLag est l'avance (en colonnes) qu'une ligne doit avoir sur la
+// suivante dans le tramage parallèle : la ligne y+1 ne traite la colonne x que
+// lorsque la ligne y a fini la colonne x+diffusionLag-1. Avec des noyaux qui
+// débordent de 2 colonnes au plus, aucune case n'est alors modifiée par deux
+// lignes à la fois.
+const diffusionLag = 4
+
+// diffuseError trame le plan de gris dans out avec le noyau k.
+//
+// Les erreurs sont des entiers : chaque case reçoit la même somme quel que
+// soit l'ordre des contributions, donc la version parallèle donne exactement
+// le résultat séquentiel (voir diffusionWavefront).
+func diffuseError(gray []uint16, out *PixelBuffer, k diffusionKernel, exec *Executor) {
+	width, height := out.Width, out.Height
+	values := make([]int32, len(gray))
+	for i, v := range gray {
+		values[i] = int32(v)
+	}
+
+	processPixel := func(x, y int) {
+		i := y*width + x
+		old := values[i]
+		white := old >= 32768
+		var quantized int32
+		if white {
+			quantized = 65535
+		}
+		out.Pix[out.offset(x, y)] = bilevelPixel(white)
+		err := old - quantized
+		for _, t := range k.taps {
+			nx, ny := x+t.dx, y+t.dy
+			if nx < 0 || nx >= width || ny >= height {
+				continue
+			}
+			values[ny*width+nx] += err * t.weight / k.divisor
+		}
+	}
+
+	diffusionWavefront(width, height, exec, processPixel)
+}
+
+// diffusionWavefront appelle process(x, y) pour chaque pixel, ligne par ligne
+// de gauche à droite. Avec exec non nil, les lignes sont traitées en front
+// d'onde : la ligne y est confiée au worker y % workers et suit la ligne y-1
+// à diffusionLag colonnes d'écart (progression publiée par compteur atomique).
+func diffusionWavefront(width, height int, exec *Executor, process func(x, y int)) {
+	workers := 1
+	if exec != nil {
+		workers = exec.numWorkers(height)
+	}
+	if workers <= 1 {
+		for y := 0; y < height; y++ {
+			for x := 0; x < width; x++ {
+				process(x, y)
+			}
+		}
+		return
+	}
+
+	// progress[y] = nombre de colonnes terminées sur la ligne y
+	progress := make([]atomic.Int32, height)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func(w int) {
+			defer wg.Done()
+			for y := w; y < height; y += workers {
+				for x := 0; x < width; {
+					// Colonnes autorisées par l'avancement de la ligne précédente
+					limit := width
+					if y > 0 {
+						for {
+							done := int(progress[y-1].Load())
+							if done == width {
+								break
+							}
+							if done-diffusionLag+1 > x {
+								limit = done - diffusionLag + 1
+								break
+							}
+							runtime.Gosched()
+						}
+					}
+					for ; x < limit; x++ {
+						process(x, y)
+					}
+					progress[y].Store(int32(x))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+}
+
+// bilevelImage convertit un buffer noir et blanc en image palettisée à deux
+// couleurs : png.Encode l'écrit alors en PNG 1 bit par pixel.
+func bilevelImage(buf *PixelBuffer) *image.Paletted {
+	img := image.NewPaletted(buf.Bounds(), color.Palette{color.Black, color.White})
+	for y := 0; y < buf.Height; y++ {
+		line := img.Pix[y*img.Stride : y*img.Stride+buf.Width]
+		for x, p := range buf.row(y) {
+			if p.R >= 32768 {
+				line[x] = 1
+			}
+		}
+	}
+	return img
+}
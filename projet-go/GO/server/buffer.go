@@ -0,0 +1,201 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// PixelBuffer stocke une image dans un seul tableau contigu de Pixel.
+// Le pixel (x, y) se trouve à l'indice y*Stride + x : une seule allocation
+// pour toute l'image, et des lignes voisines en mémoire (meilleure localité
+// de cache, moins de travail pour le GC qu'un [][]Pixel).
+type PixelBuffer struct {
+	Pix           []Pixel
+	Width, Height int
+	Stride        int
+}
+
+// newPixelBuffer alloue un buffer (noir) de la taille demandée
+func newPixelBuffer(width, height int) *PixelBuffer {
+	if width < 0 {
+		width = 0
+	}
+	if height < 0 {
+		height = 0
+	}
+	return &PixelBuffer{
+		Pix:    make([]Pixel, width*height),
+		Width:  width,
+		Height: height,
+		Stride: width,
+	}
+}
+
+// offset retourne l'indice du pixel (x, y) dans Pix
+func (b *PixelBuffer) offset(x, y int) int {
+	return y*b.Stride + x
+}
+
+// row retourne la ligne y (la tranche partage la mémoire du buffer)
+func (b *PixelBuffer) row(y int) []Pixel {
+	start := y * b.Stride
+	return b.Pix[start : start+b.Width]
+}
+
+// empty indique si le buffer ne contient aucun pixel
+func (b *PixelBuffer) empty() bool {
+	return b == nil || b.Width == 0 || b.Height == 0
+}
+
+// sameSize indique si deux buffers ont les mêmes dimensions
+func (b *PixelBuffer) sameSize(o *PixelBuffer) bool {
+	return b.Width == o.Width && b.Height == o.Height
+}
+
+// clone crée une copie profonde du buffer (compactée : Stride == Width)
+func (b *PixelBuffer) clone() *PixelBuffer {
+	dst := newPixelBuffer(b.Width, b.Height)
+	if b.Stride == b.Width {
+		copy(dst.Pix, b.Pix[:b.Width*b.Height])
+		return dst
+	}
+	for y := 0; y < b.Height; y++ {
+		copy(dst.row(y), b.row(y))
+	}
+	return dst
+}
+
+// equal compare pixel à pixel deux buffers
+func (b *PixelBuffer) equal(o *PixelBuffer) bool {
+	if !b.sameSize(o) {
+		return false
+	}
+	for y := 0; y < b.Height; y++ {
+		r1, r2 := b.row(y), o.row(y)
+		for x := range r1 {
+			if r1[x] != r2[x] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ##############################################
+// -- PixelBuffer en tant qu'image.Image / draw --
+// ##############################################
+
+// PixelBuffer implémente image.Image, draw.Image et image.RGBA64Image : il peut
+// être passé directement à jpeg.Encode, png.Encode ou draw.Draw sans passer par
+// pixelsToImage. Les pixels sont toujours opaques ; comme pour extractPixels,
+// une couleur semi-transparente écrite avec Set est composée sur du noir.
+var (
+	_ image.RGBA64Image = (*PixelBuffer)(nil)
+	_ draw.RGBA64Image  = (*PixelBuffer)(nil)
+)
+
+// ColorModel retourne color.RGBA64Model (3 canaux 16 bits)
+func (b *PixelBuffer) ColorModel() color.Model {
+	return color.RGBA64Model
+}
+
+// Bounds retourne le rectangle de l'image, d'origine (0, 0)
+func (b *PixelBuffer) Bounds() image.Rectangle {
+	return image.Rect(0, 0, b.Width, b.Height)
+}
+
+// Opaque indique au codeur PNG qu'il n'a pas besoin de canal alpha
+func (b *PixelBuffer) Opaque() bool {
+	return true
+}
+
+// At retourne la couleur du pixel (x, y), ou une couleur nulle hors de l'image
+func (b *PixelBuffer) At(x, y int) color.Color {
+	return b.RGBA64At(x, y)
+}
+
+// RGBA64At retourne la couleur du pixel (x, y) sans allocation
+func (b *PixelBuffer) RGBA64At(x, y int) color.RGBA64 {
+	if !(image.Point{x, y}.In(b.Bounds())) {
+		return color.RGBA64{}
+	}
+	p := b.Pix[b.offset(x, y)]
+	return color.RGBA64{R: p.R, G: p.G, B: p.B, A: 0xffff}
+}
+
+// Set modifie le pixel (x, y) ; ignoré hors de l'image
+func (b *PixelBuffer) Set(x, y int, c color.Color) {
+	if !(image.Point{x, y}.In(b.Bounds())) {
+		return
+	}
+	r, g, bl, _ := c.RGBA()
+	b.Pix[b.offset(x, y)] = Pixel{R: uint16(r), G: uint16(g), B: uint16(bl)}
+}
+
+// SetRGBA64 modifie le pixel (x, y) sans allocation ; ignoré hors de l'image
+func (b *PixelBuffer) SetRGBA64(x, y int, c color.RGBA64) {
+	if !(image.Point{x, y}.In(b.Bounds())) {
+		return
+	}
+	b.Pix[b.offset(x, y)] = Pixel{R: c.R, G: c.G, B: c.B}
+}
+
+// mapPixels applique f à chaque pixel d'une image modifiable quelconque.
+// Un *PixelBuffer est traité directement ; les autres images passent par
+// RGBA64At/SetRGBA64 quand elles les proposent, sinon par At/Set.
+func mapPixels(img draw.Image, f func(Pixel) Pixel) {
+	if buf, ok := img.(*PixelBuffer); ok {
+		for y := 0; y < buf.Height; y++ {
+			row := buf.row(y)
+			for x := range row {
+				row[x] = f(row[x])
+			}
+		}
+		return
+	}
+
+	r := img.Bounds()
+	if fast, ok := img.(draw.RGBA64Image); ok {
+		for y := r.Min.Y; y < r.Max.Y; y++ {
+			for x := r.Min.X; x < r.Max.X; x++ {
+				c := fast.RGBA64At(x, y)
+				p := f(Pixel{R: c.R, G: c.G, B: c.B})
+				fast.SetRGBA64(x, y, color.RGBA64{R: p.R, G: p.G, B: p.B, A: c.A})
+			}
+		}
+		return
+	}
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			cr, cg, cb, ca := img.At(x, y).RGBA()
+			p := f(Pixel{R: uint16(cr), G: uint16(cg), B: uint16(cb)})
+			img.Set(x, y, color.RGBA64{R: p.R, G: p.G, B: p.B, A: uint16(ca)})
+		}
+	}
+}
+
+// ###############################################
+// -- Adaptateurs vers l'ancienne forme [][]Pixel --
+// ###############################################
+
+// matrixToBuffer copie une matrice [][]Pixel dans un PixelBuffer
+func matrixToBuffer(rgbMatrix [][]Pixel) *PixelBuffer {
+	if len(rgbMatrix) == 0 {
+		return newPixelBuffer(0, 0)
+	}
+	b := newPixelBuffer(len(rgbMatrix[0]), len(rgbMatrix))
+	for y := 0; y < b.Height; y++ {
+		copy(b.row(y), rgbMatrix[y])
+	}
+	return b
+}
+
+// bufferToMatrix copie un PixelBuffer dans une matrice [][]Pixel
+func bufferToMatrix(b *PixelBuffer) [][]Pixel {
+	rgbMatrix := make([][]Pixel, b.Height)
+	for y := 0; y < b.Height; y++ {
+		rgbMatrix[y] = append([]Pixel{}, b.row(y)...)
+	}
+	return rgbMatrix
+}
@@ -0,0 +1,388 @@
+package main
+
+import (
+	"math"
+	"sync"
+)
+
+// Conversions entre les Pixel (sRGB 16 bits, encodés gamma) et les autres
+// espaces de couleur. Les composantes flottantes sont dans [0, 1] sauf
+// mention contraire ; les conversions vers Pixel saturent hors du gamut sRGB.
+
+// decodeSRGB convertit une valeur sRGB de [0, 1] en lumière linéaire
+func decodeSRGB(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// encodeSRGB convertit une lumière linéaire de [0, 1] en valeur sRGB
+func encodeSRGB(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+var (
+	srgbOnce     sync.Once
+	srgbToLinear []float32 // valeur 16 bits sRGB -> lumière linéaire dans [0, 1]
+	linearToSRGB []uint16  // lumière linéaire quantifiée sur 16 bits -> sRGB
+)
+
+// srgbTables retourne les tables de conversion sRGB <-> linéaire (construites
+// une seule fois, 65536 entrées chacune)
+func srgbTables() ([]float32, []uint16) {
+	srgbOnce.Do(func() {
+		srgbToLinear = make([]float32, 65536)
+		linearToSRGB = make([]uint16, 65536)
+		for i := range srgbToLinear {
+			v := float64(i) / 65535
+			srgbToLinear[i] = float32(decodeSRGB(v))
+			linearToSRGB[i] = uint16(math.Round(encodeSRGB(v) * 65535))
+		}
+	})
+	return srgbToLinear, linearToSRGB
+}
+
+// unitChannel convertit une composante de [0, 1] en canal 16 bits (saturé)
+func unitChannel(v float64) uint16 {
+	return uint16(math.Round(min(max(v, 0), 1) * 65535))
+}
+
+// linear retourne le pixel en lumière linéaire, quantifiée sur 16 bits : les
+// moyennes et les sommes pondérées de ces valeurs sont physiquement justes.
+func (p Pixel) linear() Pixel {
+	toLinear, _ := srgbTables()
+	return Pixel{
+		R: clampChannel(toLinear[p.R] * 65535),
+		G: clampChannel(toLinear[p.G] * 65535),
+		B: clampChannel(toLinear[p.B] * 65535),
+	}
+}
+
+// srgb réencode en sRGB un pixel en lumière linéaire (inverse de linear)
+func (p Pixel) srgb() Pixel {
+	_, toSRGB := srgbTables()
+	return Pixel{R: toSRGB[p.R], G: toSRGB[p.G], B: toSRGB[p.B]}
+}
+
+// linearRGB retourne les composantes en lumière linéaire, sans quantification
+func (p Pixel) linearRGB() (r, g, b float64) {
+	toLinear, _ := srgbTables()
+	return float64(toLinear[p.R]), float64(toLinear[p.G]), float64(toLinear[p.B])
+}
+
+// linearPixel encode en sRGB des composantes en lumière linéaire
+func linearPixel(r, g, b float64) Pixel {
+	enc := func(v float64) uint16 { return unitChannel(encodeSRGB(v)) }
+	return Pixel{R: enc(r), G: enc(g), B: enc(b)}
+}
+
+// unitRGB retourne les canaux du pixel dans [0, 1] (toujours encodés gamma)
+func (p Pixel) unitRGB() (r, g, b float64) {
+	return float64(p.R) / 65535, float64(p.G) / 65535, float64(p.B) / 65535
+}
+
+// hueOf retourne la teinte en degrés [0, 360) des composantes r, g, b de
+// maximum hi et d'écart hi-lo = chroma (> 0)
+func hueOf(r, g, b, hi, chroma float64) float64 {
+	var h float64
+	switch hi {
+	case r:
+		h = math.Mod((g-b)/chroma+6, 6)
+	case g:
+		h = (b-r)/chroma + 2
+	default:
+		h = (r-g)/chroma + 4
+	}
+	return h * 60
+}
+
+// hsv retourne la teinte (degrés, [0, 360)), la saturation et la valeur
+func (p Pixel) hsv() (h, s, v float64) {
+	r, g, b := p.unitRGB()
+	hi, lo := max(r, g, b), min(r, g, b)
+	if hi == lo {
+		return 0, 0, hi
+	}
+	return hueOf(r, g, b, hi, hi-lo), (hi - lo) / hi, hi
+}
+
+// hsl retourne la teinte (degrés, [0, 360)), la saturation et la clarté
+func (p Pixel) hsl() (h, s, l float64) {
+	r, g, b := p.unitRGB()
+	hi, lo := max(r, g, b), min(r, g, b)
+	l = (hi + lo) / 2
+	if hi == lo {
+		return 0, 0, l
+	}
+	chroma := hi - lo
+	return hueOf(r, g, b, hi, chroma), chroma / (1 - math.Abs(2*l-1)), l
+}
+
+// chromaPixel construit le pixel de teinte h (degrés), de chroma c, auquel
+// on ajoute m sur chaque canal (formulation commune à HSV et HSL)
+func chromaPixel(h, c, m float64) Pixel {
+	h = math.Mod(math.Mod(h, 360)+360, 360) / 60
+	x := c * (1 - math.Abs(math.Mod(h, 2)-1))
+	var r, g, b float64
+	switch int(h) {
+	case 0:
+		r, g = c, x
+	case 1:
+		r, g = x, c
+	case 2:
+		g, b = c, x
+	case 3:
+		g, b = x, c
+	case 4:
+		r, b = x, c
+	default:
+		r, b = c, x
+	}
+	return Pixel{R: unitChannel(r + m), G: unitChannel(g + m), B: unitChannel(b + m)}
+}
+
+// hsvPixel construit un pixel à partir de sa teinte (degrés), saturation et valeur
+func hsvPixel(h, s, v float64) Pixel {
+	c := v * s
+	return chromaPixel(h, c, v-c)
+}
+
+// hslPixel construit un pixel à partir de sa teinte (degrés), saturation et clarté
+func hslPixel(h, s, l float64) Pixel {
+	c := (1 - math.Abs(2*l-1)) * s
+	return chromaPixel(h, c, l-c/2)
+}
+
+// Matrices sRGB linéaire <-> CIE XYZ (blanc D65) et blanc de référence
+var (
+	rgbToXYZ = [3][3]float64{
+		{0.4124564, 0.3575761, 0.1804375},
+		{0.2126729, 0.7151522, 0.0721750},
+		{0.0193339, 0.1191920, 0.9503041},
+	}
+	xyzToRGB = [3][3]float64{
+		{3.2404542, -1.5371385, -0.4985314},
+		{-0.9692660, 1.8760108, 0.0415560},
+		{0.0556434, -0.2040259, 1.0572252},
+	}
+	whiteD65 = [3]float64{0.95047, 1, 1.08883}
+)
+
+// mulMatrix retourne m * (a, b, c)
+func mulMatrix(m [3][3]float64, a, b, c float64) (float64, float64, float64) {
+	return m[0][0]*a + m[0][1]*b + m[0][2]*c,
+		m[1][0]*a + m[1][1]*b + m[1][2]*c,
+		m[2][0]*a + m[2][1]*b + m[2][2]*c
+}
+
+// xyz retourne les coordonnées CIE XYZ (blanc D65, Y = 1 pour le blanc)
+func (p Pixel) xyz() (x, y, z float64) {
+	r, g, b := p.linearRGB()
+	return mulMatrix(rgbToXYZ, r, g, b)
+}
+
+// xyzPixel construit un pixel à partir de ses coordonnées CIE XYZ
+func xyzPixel(x, y, z float64) Pixel {
+	return linearPixel(mulMatrix(xyzToRGB, x, y, z))
+}
+
+// Constantes CIE de la fonction de Lab : epsilon = (6/29)³, kappa = (29/3)³
+const (
+	labEpsilon = 216.0 / 24389
+	labKappa   = 24389.0 / 27
+)
+
+// labF est la fonction de compression de CIE Lab
+func labF(t float64) float64 {
+	if t > labEpsilon {
+		return math.Cbrt(t)
+	}
+	return (labKappa*t + 16) / 116
+}
+
+// labFInv est l'inverse de labF
+func labFInv(f float64) float64 {
+	if t := f * f * f; t > labEpsilon {
+		return t
+	}
+	return (116*f - 16) / labKappa
+}
+
+// lab retourne les coordonnées CIE L*a*b* (blanc D65) : L dans [0, 100],
+// a et b d'environ -128 à 127
+func (p Pixel) lab() (l, a, b float64) {
+	x, y, z := p.xyz()
+	fx, fy, fz := labF(x/whiteD65[0]), labF(y/whiteD65[1]), labF(z/whiteD65[2])
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// labPixel construit un pixel à partir de ses coordonnées CIE L*a*b*
+func labPixel(l, a, b float64) Pixel {
+	fy := (l + 16) / 116
+	fx, fz := fy+a/500, fy-b/200
+	return xyzPixel(labFInv(fx)*whiteD65[0], labFInv(fy)*whiteD65[1], labFInv(fz)*whiteD65[2])
+}
+
+// ycbcr retourne la luma Y (dans [0, 1]) et les différences de couleur Cb, Cr
+// (dans [-0.5, 0.5]) selon BT.601 en pleine échelle, comme JPEG
+func (p Pixel) ycbcr() (y, cb, cr float64) {
+	r, g, b := p.unitRGB()
+	return 0.299*r + 0.587*g + 0.114*b,
+		-0.168736*r - 0.331264*g + 0.5*b,
+		0.5*r - 0.418688*g - 0.081312*b
+}
+
+// ycbcrPixel construit un pixel à partir de Y, Cb et Cr (BT.601 pleine échelle)
+func ycbcrPixel(y, cb, cr float64) Pixel {
+	return Pixel{
+		R: unitChannel(y + 1.402*cr),
+		G: unitChannel(y - 0.344136*cb - 0.714136*cr),
+		B: unitChannel(y + 1.772*cb),
+	}
+}
+
+// colorMetric est une distance entre couleurs. Chaque couleur est d'abord
+// projetée (coords) dans l'espace où la distance est calculée : les couleurs
+// fixes (palette, centres de bins) ne sont converties qu'une fois.
+type colorMetric struct {
+	coords func(p Pixel) [3]float64
+	// dist ne donne pas forcément la distance elle-même mais une valeur
+	// croissante avec elle (le carré pour les distances euclidiennes)
+	dist func(a, b [3]float64) float64
+	// bound minore dist(q, p) pour tout p de la boîte [lo, hi] : les
+	// recherches du plus proche voisin ignorent les boîtes trop éloignées.
+	bound func(q, lo, hi [3]float64) float64
+}
+
+// colorMetrics liste les distances entre couleurs disponibles
+var colorMetrics = map[string]colorMetric{
+	"rgb":       {coords: rgbCoords, dist: sqEuclid, bound: sqEuclidBound},
+	"redmean":   {coords: rgbCoords, dist: redmeanDist, bound: redmeanBound},
+	"cie76":     {coords: labCoords, dist: sqEuclid, bound: sqEuclidBound},
+	"ciede2000": {coords: labCoords, dist: ciede2000, bound: ciede2000Bound},
+}
+
+// colorMetricNames donne les clés de colorMetrics, de la plus simple à la plus fidèle
+var colorMetricNames = []string{"rgb", "redmean", "cie76", "ciede2000"}
+
+// rgbCoords retourne les canaux 16 bits du pixel
+func rgbCoords(p Pixel) [3]float64 {
+	return [3]float64{float64(p.R), float64(p.G), float64(p.B)}
+}
+
+// labCoords retourne les coordonnées CIE L*a*b* du pixel
+func labCoords(p Pixel) [3]float64 {
+	l, a, b := p.lab()
+	return [3]float64{l, a, b}
+}
+
+// sqEuclid retourne le carré de la distance euclidienne (sqDist en flottants)
+func sqEuclid(a, b [3]float64) float64 {
+	d0, d1, d2 := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return d0*d0 + d1*d1 + d2*d2
+}
+
+// boxGap retourne, sur chaque axe, l'écart entre q et l'intervalle [lo, hi]
+// (0 si q est dedans)
+func boxGap(q, lo, hi [3]float64) [3]float64 {
+	var gap [3]float64
+	for i := range gap {
+		gap[i] = max(lo[i]-q[i], q[i]-hi[i], 0)
+	}
+	return gap
+}
+
+// sqEuclidBound est le carré de la distance de q à la boîte
+func sqEuclidBound(q, lo, hi [3]float64) float64 {
+	g := boxGap(q, lo, hi)
+	return g[0]*g[0] + g[1]*g[1] + g[2]*g[2]
+}
+
+// redmeanBound minore redmeanDist : les poids du rouge et du bleu valent au
+// moins 512, quel que soit le rouge moyen
+func redmeanBound(q, lo, hi [3]float64) float64 {
+	g := boxGap(q, lo, hi)
+	return 512*g[0]*g[0] + 1024*g[1]*g[1] + 512*g[2]*g[2]
+}
+
+// redmeanDist est l'approximation "redmean" de la distance perçue, sur des
+// canaux 16 bits : les écarts de rouge et de bleu pèsent plus ou moins selon
+// le rouge moyen des deux couleurs (poids 8 bits (2 + r/256, 4, 2 + (255-r)/256),
+// multipliés par 256). Les calculs restent exacts en float64.
+func redmeanDist(a, b [3]float64) float64 {
+	rmean := math.Floor((a[0] + b[0]) / 512) // moyenne, ramenée sur 8 bits
+	dR, dG, dB := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return (512+rmean)*dR*dR + 1024*dG*dG + (767-rmean)*dB*dB
+}
+
+// ciede2000MaxSL est le plus grand facteur de clarté SL de CIEDE2000 (atteint
+// pour une clarté moyenne de 0 ou 100)
+var ciede2000MaxSL = 1 + 0.015*2500/math.Sqrt(20+2500)
+
+// ciede2000Bound minore ciede2000 par le seul écart de clarté : les termes de
+// saturation et de teinte, rotation RT comprise (|RT| <= 2), sont positifs,
+// et ΔL' = ΔL est divisé par SL <= ciede2000MaxSL.
+func ciede2000Bound(q, lo, hi [3]float64) float64 {
+	return boxGap(q, lo, hi)[0] / ciede2000MaxSL
+}
+
+// ciede2000 retourne l'écart de couleur CIEDE2000 entre deux couleurs Lab
+// (formule de Sharma, Wu et Dalal, 2005) : CIE76 corrigé pour la clarté, la
+// saturation et la teinte, notamment dans les bleus.
+func ciede2000(x, y [3]float64) float64 {
+	const pow25to7 = 6103515625 // 25^7
+	l1, a1, b1 := x[0], x[1], x[2]
+	l2, a2, b2 := y[0], y[1], y[2]
+
+	cBar := (math.Hypot(a1, b1) + math.Hypot(a2, b2)) / 2
+	pow7 := func(v float64) float64 { v2 := v * v; return v2 * v2 * v2 * v }
+	cBar7 := pow7(cBar)
+	g := 0.5 * (1 - math.Sqrt(cBar7/(cBar7+pow25to7)))
+	a1p, a2p := (1+g)*a1, (1+g)*a2
+	c1p, c2p := math.Hypot(a1p, b1), math.Hypot(a2p, b2)
+	hue := func(b, a float64) float64 {
+		if a == 0 && b == 0 {
+			return 0
+		}
+		return math.Mod(math.Atan2(b, a)*180/math.Pi+360, 360)
+	}
+	h1p, h2p := hue(b1, a1p), hue(b2, a2p)
+
+	dLp, dCp := l2-l1, c2p-c1p
+	var dhp, hBarp float64
+	switch {
+	case c1p*c2p == 0:
+		dhp, hBarp = 0, h1p+h2p
+	case math.Abs(h2p-h1p) <= 180:
+		dhp, hBarp = h2p-h1p, (h1p+h2p)/2
+	case h2p-h1p > 180:
+		dhp, hBarp = h2p-h1p-360, (h1p+h2p+360)/2
+	default:
+		dhp, hBarp = h2p-h1p+360, (h1p+h2p+360)/2
+	}
+	if c1p*c2p != 0 && hBarp >= 360 {
+		hBarp -= 360
+	}
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(rad(dhp/2))
+
+	lBarp, cBarp := (l1+l2)/2, (c1p+c2p)/2
+	t := 1 - 0.17*math.Cos(rad(hBarp-30)) + 0.24*math.Cos(rad(2*hBarp)) +
+		0.32*math.Cos(rad(3*hBarp+6)) - 0.20*math.Cos(rad(4*hBarp-63))
+	dh275 := (hBarp - 275) / 25
+	dTheta := 30 * math.Exp(-dh275*dh275)
+	cBarp7 := pow7(cBarp)
+	rc := 2 * math.Sqrt(cBarp7/(cBarp7+pow25to7))
+	l50 := (lBarp - 50) * (lBarp - 50)
+	sl := 1 + 0.015*l50/math.Sqrt(20+l50)
+	sc := 1 + 0.045*cBarp
+	sh := 1 + 0.015*cBarp*t
+	rt := -math.Sin(rad(2*dTheta)) * rc
+
+	dl, dc, dh := dLp/sl, dCp/sc, dHp/sh
+	return math.Sqrt(dl*dl + dc*dc + dh*dh + rt*dc*dh)
+}
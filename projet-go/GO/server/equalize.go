@@ -0,0 +1,278 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sync"
+)
+
+// equalizeOptions décrit une amélioration du contraste par histogramme. Seule
+// la luminance (BT.601, comme blackWhite) est modifiée : les écarts R-Y et
+// B-Y sont conservés, les couleurs ne changent pas de teinte.
+type equalizeOptions struct {
+	// Method : "global" (égalisation de l'histogramme de toute l'image) ou
+	// "clahe" (égalisation par tuiles à contraste limité, interpolée entre
+	// les tuiles).
+	Method string
+	// TilesX et TilesY sont le nombre de tuiles de "clahe" en largeur et en hauteur.
+	TilesX, TilesY int
+	// ClipLimit (>= 1) limite chaque case de l'histogramme d'une tuile à
+	// ClipLimit fois la moyenne ; l'excédent est réparti sur toutes les cases.
+	// Plus la limite est basse, moins le bruit des zones uniformes est amplifié.
+	ClipLimit float64
+}
+
+// defaultEqualizeOptions : CLAHE sur 8x8 tuiles, limite 2.
+var defaultEqualizeOptions = equalizeOptions{
+	Method:    "clahe",
+	TilesX:    8,
+	TilesY:    8,
+	ClipLimit: 2,
+}
+
+// equalizeMethods liste les méthodes acceptées par equalizeOptions.Method.
+var equalizeMethods = []string{"global", "clahe"}
+
+// claheBins est le nombre de cases des histogrammes de tuile (octet de poids
+// fort de la luminance ; l'octet faible sert à interpoler dans la case).
+const claheBins = 256
+
+// checkEqualize retourne une erreur si les options sont invalides
+func checkEqualize(opts equalizeOptions) error {
+	switch opts.Method {
+	case "global":
+		return nil
+	case "clahe":
+		if opts.TilesX < 1 || opts.TilesY < 1 || opts.TilesX > 64 || opts.TilesY > 64 {
+			return fmt.Errorf("grille de %dx%d tuiles hors de [1, 64]", opts.TilesX, opts.TilesY)
+		}
+		// Au-delà de claheBins, aucune case n'est écrêtée (et un NaN passerait
+		// toutes les comparaisons)
+		if math.IsNaN(opts.ClipLimit) || opts.ClipLimit < 1 || opts.ClipLimit > claheBins {
+			return fmt.Errorf("limite de contraste %v hors de [1, %d]", opts.ClipLimit, claheBins)
+		}
+		return nil
+	}
+	return fmt.Errorf("méthode d'égalisation inconnue %q", opts.Method)
+}
+
+// equalizePixels améliore le contraste de l'image (séquentiel, in-place).
+// Retourne nil si les options sont invalides.
+func equalizePixels(buf *PixelBuffer, opts equalizeOptions) *PixelBuffer {
+	return equalize(buf, opts, nil)
+}
+
+// equalize est l'implémentation commune : exec nil = séquentiel, sinon les
+// histogrammes (par bandes ou par tuiles) et les pixels sont répartis par exec.
+// Les histogrammes sont des comptes entiers : le résultat ne dépend pas du découpage.
+func equalize(buf *PixelBuffer, opts equalizeOptions, exec *Executor) *PixelBuffer {
+	if checkEqualize(opts) != nil {
+		return nil
+	}
+	if buf.empty() {
+		return buf
+	}
+	luma := grayPlane(buf, defaultGrayOptions, exec)
+	var mapLuma func(x, y int, v uint16) uint16
+	if opts.Method == "global" {
+		lut := globalEqualization(luma, exec)
+		mapLuma = func(x, y int, v uint16) uint16 { return lut[v] }
+	} else {
+		mapLuma = newClahe(luma, buf.Width, buf.Height, opts, exec).mapLuma
+	}
+
+	runRows(exec, buf.Height, func(start, end int) {
+		for y := start; y < end; y++ {
+			row := buf.row(y)
+			in := luma[y*buf.Width : (y+1)*buf.Width]
+			for x, v := range in {
+				row[x] = setLuma(row[x], v, mapLuma(x, y, v))
+			}
+		}
+	})
+	return buf
+}
+
+// setLuma retourne p avec la luminance newY au lieu de y, en gardant les
+// écarts de couleur R-Y et B-Y (G s'en déduit par la pondération BT.601)
+func setLuma(p Pixel, y, newY uint16) Pixel {
+	if y == newY {
+		return p
+	}
+	ny := float32(newY)
+	r := ny + float32(p.R) - float32(y)
+	b := ny + float32(p.B) - float32(y)
+	g := (ny - 0.299*r - 0.114*b) / 0.587
+	return Pixel{R: clampChannel(r), G: clampChannel(g), B: clampChannel(b)}
+}
+
+// globalEqualization retourne la table d'égalisation de l'histogramme de
+// luminance : chaque valeur est envoyée sur sa fréquence cumulée.
+func globalEqualization(luma []uint16, exec *Executor) []uint16 {
+	hist := make([]int, 65536)
+	if exec == nil {
+		for _, v := range luma {
+			hist[v]++
+		}
+	} else {
+		// Histogrammes partiels par bande, fusionnés ensuite
+		var mu sync.Mutex
+		exec.Rows(len(luma), func(start, end int) {
+			local := make([]int, len(hist))
+			for _, v := range luma[start:end] {
+				local[v]++
+			}
+			mu.Lock()
+			for i, c := range local {
+				hist[i] += c
+			}
+			mu.Unlock()
+		})
+	}
+
+	// La plus petite valeur présente va sur 0, la plus grande sur 65535
+	cdfMin := 0
+	for _, c := range hist {
+		if c > 0 {
+			cdfMin = c
+			break
+		}
+	}
+	lut := make([]uint16, 65536)
+	total := len(luma)
+	if total == cdfMin {
+		// Image uniforme : rien à étirer
+		for i := range lut {
+			lut[i] = uint16(i)
+		}
+		return lut
+	}
+	cdf := 0
+	for i, c := range hist {
+		cdf += c
+		lut[i] = uint16(max(0, cdf-cdfMin) * 65535 / (total - cdfMin))
+	}
+	return lut
+}
+
+// clahe contient les tables des tuiles et de quoi interpoler entre elles
+type clahe struct {
+	tilesX int
+	// luts[t] a claheBins+1 entrées : luts[t][b] est la valeur de sortie du
+	// bas de la case b de la tuile t, luts[t][b+1] celle du haut.
+	luts [][]uint16
+	// Pour chaque colonne (ligne) : tuile de gauche (du haut) et poids de la
+	// tuile suivante dans l'interpolation bilinéaire entre centres de tuiles.
+	col0, row0       []int
+	colFrac, rowFrac []float32
+}
+
+// newClahe calcule les tables de toutes les tuiles
+func newClahe(luma []uint16, width, height int, opts equalizeOptions, exec *Executor) *clahe {
+	tilesX, tilesY := min(opts.TilesX, width), min(opts.TilesY, height)
+	c := &clahe{tilesX: tilesX, luts: make([][]uint16, tilesX*tilesY)}
+	// Tuile i d'un axe de longueur n : [i*n/tiles, (i+1)*n/tiles)
+	bound := func(i, n, tiles int) int { return i * n / tiles }
+
+	runRows(exec, len(c.luts), func(start, end int) {
+		for t := start; t < end; t++ {
+			tx, ty := t%tilesX, t/tilesX
+			x0, x1 := bound(tx, width, tilesX), bound(tx+1, width, tilesX)
+			y0, y1 := bound(ty, height, tilesY), bound(ty+1, height, tilesY)
+			var hist [claheBins]int
+			for y := y0; y < y1; y++ {
+				for _, v := range luma[y*width+x0 : y*width+x1] {
+					hist[v>>8]++
+				}
+			}
+			c.luts[t] = clippedEqualization(hist, (x1-x0)*(y1-y0), opts.ClipLimit)
+		}
+	})
+
+	c.col0, c.colFrac = tileInterpolation(width, tilesX)
+	c.row0, c.rowFrac = tileInterpolation(height, tilesY)
+	return c
+}
+
+// clippedEqualization retourne la table (claheBins+1 entrées) d'égalisation de
+// l'histogramme d'une tuile de total pixels, écrêté à clipLimit fois la moyenne
+func clippedEqualization(hist [claheBins]int, total int, clipLimit float64) []uint16 {
+	limit := max(1, int(clipLimit*float64(total)/claheBins))
+	excess := 0
+	for i, c := range hist {
+		if c > limit {
+			excess += c - limit
+			hist[i] = limit
+		}
+	}
+	// Répartition de l'excédent : une part égale par case, le reste une
+	// unité par case à pas réguliers
+	share, rest := excess/claheBins, excess%claheBins
+	for i := range hist {
+		hist[i] += share
+	}
+	if rest > 0 {
+		step := claheBins / rest
+		for i := 0; i < rest; i++ {
+			hist[i*step]++
+		}
+	}
+
+	lut := make([]uint16, claheBins+1)
+	cdf := 0
+	for i, c := range hist {
+		cdf += c
+		lut[i+1] = uint16(cdf * 65535 / total)
+	}
+	return lut
+}
+
+// tileInterpolation retourne, pour chaque position d'un axe de longueur n
+// découpé en tiles tuiles, la tuile dont le centre précède la position et le
+// poids de la tuile suivante (0 avant le premier centre et après le dernier)
+func tileInterpolation(n, tiles int) ([]int, []float32) {
+	centers := make([]float32, tiles)
+	for i := range centers {
+		centers[i] = float32(i*n/tiles+(i+1)*n/tiles) / 2
+	}
+	first := make([]int, n)
+	frac := make([]float32, n)
+	t := 0
+	for p := 0; p < n; p++ {
+		pos := float32(p) + 0.5
+		for t+1 < tiles && centers[t+1] <= pos {
+			t++
+		}
+		first[p] = t
+		if t+1 < tiles && pos > centers[t] {
+			frac[p] = (pos - centers[t]) / (centers[t+1] - centers[t])
+		}
+	}
+	return first, frac
+}
+
+// lookup retourne la luminance v transformée par la table de la tuile t,
+// interpolée dans la case selon l'octet faible
+func (c *clahe) lookup(t int, v uint16) float32 {
+	lut := c.luts[t]
+	b := v >> 8
+	lo, hi := float32(lut[b]), float32(lut[b+1])
+	return lo + (hi-lo)*(float32(v&0xff)+0.5)/256
+}
+
+// mapLuma retourne la nouvelle luminance du pixel (x, y) de luminance v :
+// interpolation bilinéaire des tables des quatre tuiles les plus proches
+func (c *clahe) mapLuma(x, y int, v uint16) uint16 {
+	tx, ty := c.col0[x], c.row0[y]
+	fx, fy := c.colFrac[x], c.rowFrac[y]
+	tx1, ty1 := tx, ty
+	if fx > 0 {
+		tx1++
+	}
+	if fy > 0 {
+		ty1++
+	}
+	top := c.lookup(ty*c.tilesX+tx, v)*(1-fx) + c.lookup(ty*c.tilesX+tx1, v)*fx
+	bottom := c.lookup(ty1*c.tilesX+tx, v)*(1-fx) + c.lookup(ty1*c.tilesX+tx1, v)*fx
+	return clampChannel(top*(1-fy) + bottom*fy)
+}
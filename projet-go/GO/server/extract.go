@@ -0,0 +1,169 @@
+package main
+
+import (
+	"image"
+)
+
+// rowExtractor retourne une fonction qui remplit dst avec les len(dst) premiers
+// pixels de la ligne y de m.
+//
+// Pour les types concrets courants (*image.YCbCr des JPEG, RGBA, NRGBA, Gray,
+// Paletted et leurs versions 16 bits), la fonction lit directement les tableaux
+// Pix sous-jacents au lieu d'appeler m.At(x, y).RGBA(), qui alloue une couleur
+// (interface) par pixel. Les conversions reproduisent exactement celles de
+// image/color : le résultat est identique bit à bit au chemin générique.
+// La fonction retournée ne modifie rien et peut être appelée en parallèle.
+func rowExtractor(m image.Image) func(y int, dst []Pixel) {
+	// Les chemins rapides supposent une origine en (0, 0), ce qui est le cas
+	// de toutes les images décodées ; sinon on garde le chemin générique.
+	if m.Bounds().Min != (image.Point{}) {
+		return func(y int, dst []Pixel) { extractRowGeneric(m, y, dst) }
+	}
+	maxX, maxY := m.Bounds().Max.X, m.Bounds().Max.Y
+
+	var fast func(y int, dst []Pixel)
+	switch img := m.(type) {
+	case *PixelBuffer:
+		fast = func(y int, dst []Pixel) { copy(dst, img.row(y)) }
+	case *image.YCbCr:
+		fast = func(y int, dst []Pixel) { extractRowYCbCr(img, y, dst) }
+	case *image.RGBA:
+		fast = func(y int, dst []Pixel) {
+			pix := img.Pix[y*img.Stride:]
+			for x := range dst {
+				i := 4 * x
+				dst[x] = Pixel{
+					R: uint16(pix[i+0]) * 0x101,
+					G: uint16(pix[i+1]) * 0x101,
+					B: uint16(pix[i+2]) * 0x101,
+				}
+			}
+		}
+	case *image.RGBA64:
+		fast = func(y int, dst []Pixel) {
+			pix := img.Pix[y*img.Stride:]
+			for x := range dst {
+				i := 8 * x
+				dst[x] = Pixel{
+					R: uint16(pix[i+0])<<8 | uint16(pix[i+1]),
+					G: uint16(pix[i+2])<<8 | uint16(pix[i+3]),
+					B: uint16(pix[i+4])<<8 | uint16(pix[i+5]),
+				}
+			}
+		}
+	case *image.NRGBA:
+		fast = func(y int, dst []Pixel) {
+			pix := img.Pix[y*img.Stride:]
+			for x := range dst {
+				i := 4 * x
+				a := uint32(pix[i+3])
+				dst[x] = Pixel{
+					R: uint16(uint32(pix[i+0]) * 0x101 * a / 0xff),
+					G: uint16(uint32(pix[i+1]) * 0x101 * a / 0xff),
+					B: uint16(uint32(pix[i+2]) * 0x101 * a / 0xff),
+				}
+			}
+		}
+	case *image.NRGBA64:
+		fast = func(y int, dst []Pixel) {
+			pix := img.Pix[y*img.Stride:]
+			for x := range dst {
+				i := 8 * x
+				a := uint32(pix[i+6])<<8 | uint32(pix[i+7])
+				r := uint32(pix[i+0])<<8 | uint32(pix[i+1])
+				g := uint32(pix[i+2])<<8 | uint32(pix[i+3])
+				b := uint32(pix[i+4])<<8 | uint32(pix[i+5])
+				dst[x] = Pixel{
+					R: uint16(r * a / 0xffff),
+					G: uint16(g * a / 0xffff),
+					B: uint16(b * a / 0xffff),
+				}
+			}
+		}
+	case *image.Gray:
+		fast = func(y int, dst []Pixel) {
+			pix := img.Pix[y*img.Stride:]
+			for x := range dst {
+				v := uint16(pix[x]) * 0x101
+				dst[x] = Pixel{R: v, G: v, B: v}
+			}
+		}
+	case *image.Gray16:
+		fast = func(y int, dst []Pixel) {
+			pix := img.Pix[y*img.Stride:]
+			for x := range dst {
+				v := uint16(pix[2*x])<<8 | uint16(pix[2*x+1])
+				dst[x] = Pixel{R: v, G: v, B: v}
+			}
+		}
+	case *image.Paletted:
+		if len(img.Palette) == 0 {
+			break
+		}
+		// Conversion de la palette une seule fois, puis simple indexation.
+		palette := make([]Pixel, 256)
+		for i := range palette {
+			if i >= len(img.Palette) {
+				// Un index hors palette ferait paniquer At : on garde ce comportement.
+				palette = palette[:i]
+				break
+			}
+			r, g, b, _ := img.Palette[i].RGBA()
+			palette[i] = Pixel{R: uint16(r), G: uint16(g), B: uint16(b)}
+		}
+		fast = func(y int, dst []Pixel) {
+			pix := img.Pix[y*img.Stride:]
+			for x := range dst {
+				dst[x] = palette[pix[x]]
+			}
+		}
+	}
+
+	if fast == nil {
+		return func(y int, dst []Pixel) { extractRowGeneric(m, y, dst) }
+	}
+	return func(y int, dst []Pixel) {
+		// Hors de l'image, At retourne une couleur nulle : le chemin générique
+		// s'en charge.
+		if y >= maxY || len(dst) > maxX {
+			extractRowGeneric(m, y, dst)
+			return
+		}
+		fast(y, dst)
+	}
+}
+
+// extractRowGeneric remplit dst via l'interface image.Image (chemin lent mais universel)
+func extractRowGeneric(m image.Image, y int, dst []Pixel) {
+	for x := range dst {
+		r, g, b, _ := m.At(x, y).RGBA()
+		dst[x] = Pixel{R: uint16(r), G: uint16(g), B: uint16(b)}
+	}
+}
+
+// extractRowYCbCr lit une ligne d'une image YCbCr (JPEG) en reprenant la
+// conversion 16 bits de color.YCbCr.RGBA.
+func extractRowYCbCr(img *image.YCbCr, y int, dst []Pixel) {
+	for x := range dst {
+		yi := img.YOffset(x, y)
+		ci := img.COffset(x, y)
+
+		yy1 := int32(img.Y[yi]) * 0x10101
+		cb1 := int32(img.Cb[ci]) - 128
+		cr1 := int32(img.Cr[ci]) - 128
+
+		dst[x] = Pixel{
+			R: clampYCbCr(yy1 + 91881*cr1),
+			G: clampYCbCr(yy1 - 22554*cb1 - 46802*cr1),
+			B: clampYCbCr(yy1 + 116130*cb1),
+		}
+	}
+}
+
+// clampYCbCr ramène une valeur intermédiaire de la conversion YCbCr dans [0, 0xffff]
+func clampYCbCr(v int32) uint16 {
+	if uint32(v)&0xff000000 == 0 {
+		return uint16(v >> 8)
+	}
+	return uint16(^(v >> 31) & 0xffff)
+}
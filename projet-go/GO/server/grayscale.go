@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// grayOptions décrit une conversion en niveaux de gris.
+type grayOptions struct {
+	// Mode : "bt601" (défaut, comme blackWhite), "bt709", "linear" (bt709
+	// avec Linear : la luminance physique, réencodée en sRGB), "average",
+	// "lightness" ((max+min)/2), "red", "green", "blue" (un seul canal) ou
+	// "custom" (Weights).
+	Mode string
+	// Weights sont les poids R, G, B du mode "custom" (normalisés par leur somme).
+	Weights [3]float64
+	// Amount est le pourcentage de désaturation : 100 donne du gris pur,
+	// 0 laisse l'image intacte, 50 garde la moitié de la couleur.
+	Amount float64
+	// Linear calcule le gris et le mélange en lumière linéaire (puis réencode
+	// en sRGB) : les sommes pondérées ne sont plus assombries par le gamma.
+	Linear bool
+}
+
+// defaultGrayOptions correspond à blackWhite : BT.601, désaturation complète.
+var defaultGrayOptions = grayOptions{Mode: "bt601", Amount: 100}
+
+// grayModes liste les modes acceptés par grayOptions.Mode.
+var grayModes = []string{"bt601", "bt709", "linear", "average", "lightness", "red", "green", "blue", "custom"}
+
+// grayConverter retourne la fonction pixel -> pixel correspondant aux options,
+// ou une erreur si le mode, les poids ou le pourcentage sont invalides.
+func grayConverter(opts grayOptions) (func(Pixel) Pixel, error) {
+	if math.IsNaN(opts.Amount) || opts.Amount < 0 || opts.Amount > 100 {
+		return nil, fmt.Errorf("pourcentage de désaturation %v hors de [0, 100]", opts.Amount)
+	}
+
+	var gray func(p Pixel) uint16
+	switch opts.Mode {
+	case "", "bt601":
+		gray = func(p Pixel) uint16 { return grayPixel(p).R }
+	case "bt709":
+		gray = weightedGray(0.2126, 0.7152, 0.0722)
+	case "linear":
+		gray = weightedGray(0.2126, 0.7152, 0.0722)
+		opts.Linear = true
+	case "average":
+		gray = func(p Pixel) uint16 { return uint16((uint32(p.R) + uint32(p.G) + uint32(p.B)) / 3) }
+	case "lightness":
+		gray = func(p Pixel) uint16 {
+			hi := max(p.R, p.G, p.B)
+			lo := min(p.R, p.G, p.B)
+			return uint16((uint32(hi) + uint32(lo)) / 2)
+		}
+	case "red":
+		gray = func(p Pixel) uint16 { return p.R }
+	case "green":
+		gray = func(p Pixel) uint16 { return p.G }
+	case "blue":
+		gray = func(p Pixel) uint16 { return p.B }
+	case "custom":
+		w := opts.Weights
+		sum := w[0] + w[1] + w[2]
+		// NaN et Inf passeraient les comparaisons : la somme doit être finie
+		if w[0] < 0 || w[1] < 0 || w[2] < 0 || math.IsNaN(sum) || math.IsInf(sum, 0) || sum <= 0 {
+			return nil, fmt.Errorf("poids personnalisés invalides %v (positifs, finis, somme non nulle)", w)
+		}
+		gray = weightedGray(w[0]/sum, w[1]/sum, w[2]/sum)
+	default:
+		return nil, fmt.Errorf("mode de gris inconnu %q", opts.Mode)
+	}
+
+	convert := func(p Pixel) Pixel {
+		g := gray(p)
+		return Pixel{R: g, G: g, B: g}
+	}
+	if opts.Amount < 100 {
+		amount := float32(opts.Amount / 100)
+		mix := func(c, g uint16) uint16 {
+			return clampChannel(float32(c) + amount*(float32(g)-float32(c)))
+		}
+		convert = func(p Pixel) Pixel {
+			g := gray(p)
+			return Pixel{R: mix(p.R, g), G: mix(p.G, g), B: mix(p.B, g)}
+		}
+	}
+	if opts.Linear {
+		inSRGB := convert
+		convert = func(p Pixel) Pixel { return inSRGB(p.linear()).srgb() }
+	}
+	return convert, nil
+}
+
+// weightedGray retourne une conversion par somme pondérée des canaux
+func weightedGray(wR, wG, wB float64) func(p Pixel) uint16 {
+	return func(p Pixel) uint16 {
+		return uint16(math.Min(wR*float64(p.R)+wG*float64(p.G)+wB*float64(p.B), 65535))
+	}
+}
+
+// grayscalePixels convertit le buffer en niveaux de gris selon opts
+// (séquentiel, in-place). Retourne nil si les options sont invalides.
+func grayscalePixels(buf *PixelBuffer, opts grayOptions) *PixelBuffer {
+	convert, err := grayConverter(opts)
+	if err != nil {
+		return nil
+	}
+	for y := 0; y < buf.Height; y++ {
+		row := buf.row(y)
+		for x := range row {
+			row[x] = convert(row[x])
+		}
+	}
+	return buf
+}
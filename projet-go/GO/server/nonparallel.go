@@ -1,14 +1,17 @@
 package main
 
 import (
+	"fmt"
 	"image"
-	"image/color"
+	"image/draw"
 	_ "image/jpeg" // Indispensable pour décoder le JPEG (init function)
 	"image/png"
 	_ "image/png" // Indispensable pour décoder le PNG (init function)
 	"log"
-	"math/rand"
+	"math/rand/v2"
 	"os"
+	"slices"
+	"sync"
 )
 
 // Pixel représente une valeur RGB
@@ -31,24 +34,15 @@ func loadImage(filename string) image.Image {
 	return m
 }
 
-// extractPixels convertit une image en matrice de pixels RGB (séquentiel)
-func extractPixels(m image.Image, width, height int) [][]Pixel {
-	rgbMatrix := make([][]Pixel, height)
+// extractPixels convertit une image en buffer de pixels RGB (séquentiel)
+func extractPixels(m image.Image, width, height int) *PixelBuffer {
+	buf := newPixelBuffer(width, height)
+	extractRow := rowExtractor(m)
 
 	for y := 0; y < height; y++ {
-		rgbMatrix[y] = make([]Pixel, width)
-
-		for x := 0; x < width; x++ {
-			r, g, b, _ := m.At(x, y).RGBA()
-
-			rgbMatrix[y][x] = Pixel{
-				R: uint16(r),
-				G: uint16(g),
-				B: uint16(b),
-			}
-		}
+		extractRow(y, buf.row(y))
 	}
-	return rgbMatrix
+	return buf
 }
 
 // copyMatrix crée une copie profonde de la matrice
@@ -60,43 +54,51 @@ func copyMatrix(src [][]Pixel) [][]Pixel {
 	return dst
 }
 
-// blackWhite convertit la matrice en niveaux de gris (séquentiel, in-place)
-func blackWhite(rgbMatrix [][]Pixel, width, height int) [][]Pixel {
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			p := rgbMatrix[y][x]
-			gray := uint16(0.299*float64(p.R) + 0.587*float64(p.G) + 0.114*float64(p.B))
-			rgbMatrix[y][x] = Pixel{R: gray, G: gray, B: gray}
+// grayPixel retourne le niveau de gris (pondération BT.601) d'un pixel
+func grayPixel(p Pixel) Pixel {
+	gray := uint16(0.299*float64(p.R) + 0.587*float64(p.G) + 0.114*float64(p.B))
+	return Pixel{R: gray, G: gray, B: gray}
+}
+
+// blackWhite convertit le buffer en niveaux de gris (séquentiel, in-place)
+func blackWhite(buf *PixelBuffer) *PixelBuffer {
+	for y := 0; y < buf.Height; y++ {
+		row := buf.row(y)
+		for x := range row {
+			row[x] = grayPixel(row[x])
 		}
 	}
-	return rgbMatrix
+	return buf
 }
 
-// pixelsToImage convertit une matrice de pixels en image RGBA
-func pixelsToImage(rgbMatrix [][]Pixel) *image.RGBA {
-	if len(rgbMatrix) == 0 || len(rgbMatrix[0]) == 0 {
+// blackWhiteImage convertit en niveaux de gris n'importe quelle image
+// modifiable (in-place), sans conversion préalable en PixelBuffer
+func blackWhiteImage(img draw.Image) {
+	mapPixels(img, grayPixel)
+}
+
+// pixelsToImage convertit un buffer de pixels en image RGBA
+func pixelsToImage(buf *PixelBuffer) *image.RGBA {
+	if buf.empty() {
 		return image.NewRGBA(image.Rect(0, 0, 0, 0))
 	}
-	height := len(rgbMatrix)
-	width := len(rgbMatrix[0])
-	out := image.NewRGBA(image.Rect(0, 0, width, height))
-
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			p := rgbMatrix[y][x]
-			out.Set(x, y, color.RGBA{
-				R: uint8(p.R >> 8),
-				G: uint8(p.G >> 8),
-				B: uint8(p.B >> 8),
-				A: 255,
-			})
+	out := image.NewRGBA(image.Rect(0, 0, buf.Width, buf.Height))
+
+	for y := 0; y < buf.Height; y++ {
+		row := buf.row(y)
+		dst := out.Pix[y*out.Stride : y*out.Stride+4*buf.Width]
+		for x, p := range row {
+			dst[4*x+0] = uint8(p.R >> 8)
+			dst[4*x+1] = uint8(p.G >> 8)
+			dst[4*x+2] = uint8(p.B >> 8)
+			dst[4*x+3] = 255
 		}
 	}
 	return out
 }
 
-// saveImage sauvegarde une image en PNG
-func saveImage(img *image.RGBA, filename string) {
+// saveImage sauvegarde une image en PNG (un *PixelBuffer peut être passé directement)
+func saveImage(img image.Image, filename string) {
 	file, err := os.Create(filename)
 	if err != nil {
 		log.Fatal(err)
@@ -110,20 +112,41 @@ func saveImage(img *image.RGBA, filename string) {
 }
 
 // downscalePixels réduit la définition sans changer la taille (pixelisation)
-func downscalePixels(rgbMatrix [][]Pixel, width, height, factor int) [][]Pixel {
-	if factor <= 1 {
-		return rgbMatrix
-	}
-	if len(rgbMatrix) == 0 || len(rgbMatrix[0]) == 0 {
-		return rgbMatrix
+func downscalePixels(buf *PixelBuffer, factor int) *PixelBuffer {
+	if factor <= 1 || buf.empty() {
+		return buf
 	}
+	result := newPixelBuffer(buf.Width, buf.Height)
+	downscaleBlockRows(buf, result, factor, false, 0, blockCount(buf.Height, factor))
+	return result
+}
 
-	result := make([][]Pixel, height)
-	for y := 0; y < height; y++ {
-		result[y] = make([]Pixel, width)
+// downscalePixelsLinear pixelise comme downscalePixels, mais moyenne les
+// blocs en lumière linéaire : un bloc à moitié noir et à moitié blanc donne
+// le gris de même luminosité perçue de loin, et non un gris trop sombre.
+func downscalePixelsLinear(buf *PixelBuffer, factor int) *PixelBuffer {
+	if factor <= 1 || buf.empty() {
+		return buf
 	}
+	result := newPixelBuffer(buf.Width, buf.Height)
+	downscaleBlockRows(buf, result, factor, true, 0, blockCount(buf.Height, factor))
+	return result
+}
 
-	for by := 0; by < height; by += factor {
+// blockCount retourne le nombre de blocs de factor unités nécessaires pour couvrir n
+func blockCount(n, factor int) int {
+	return (n + factor - 1) / factor
+}
+
+// downscaleBlockRows pixelise les rangées de blocs [startBlock, endBlock) de src
+// dans dst. Le découpage se fait en rangées de blocs (et non en lignes) : un
+// bloc est toujours traité en entier, quelle que soit la répartition du travail.
+// Si linear, les moyennes sont faites en lumière linéaire.
+func downscaleBlockRows(src, dst *PixelBuffer, factor int, linear bool, startBlock, endBlock int) {
+	width, height := src.Width, src.Height
+	toLinear, _ := srgbTables()
+	for blockY := startBlock; blockY < endBlock; blockY++ {
+		by := blockY * factor
 		for bx := 0; bx < width; bx += factor {
 			var sumR, sumG, sumB uint64
 			count := 0
@@ -137,8 +160,16 @@ func downscalePixels(rgbMatrix [][]Pixel, width, height, factor int) [][]Pixel {
 			}
 
 			for y := by; y < maxY; y++ {
+				row := src.row(y)
 				for x := bx; x < maxX; x++ {
-					p := rgbMatrix[y][x]
+					p := row[x]
+					if linear {
+						p = Pixel{
+							R: clampChannel(toLinear[p.R] * 65535),
+							G: clampChannel(toLinear[p.G] * 65535),
+							B: clampChannel(toLinear[p.B] * 65535),
+						}
+					}
 					sumR += uint64(p.R)
 					sumG += uint64(p.G)
 					sumB += uint64(p.B)
@@ -151,16 +182,18 @@ func downscalePixels(rgbMatrix [][]Pixel, width, height, factor int) [][]Pixel {
 				G: uint16(sumG / uint64(count)),
 				B: uint16(sumB / uint64(count)),
 			}
+			if linear {
+				avg = avg.srgb()
+			}
 
 			for y := by; y < maxY; y++ {
+				row := dst.row(y)
 				for x := bx; x < maxX; x++ {
-					result[y][x] = avg
+					row[x] = avg
 				}
 			}
 		}
 	}
-
-	return result
 }
 
 // Remap pixels from a source image to match the color distribution of a target image
@@ -207,10 +240,10 @@ func sqDist(aR, aG, aB, bR, bG, bB int) int {
 }
 
 // buildSourceBins groups source pixels into bins and keeps their exact values.
-func buildSourceBins(src [][]Pixel, levels int) [][]Pixel {
+func buildSourceBins(src *PixelBuffer, levels int) [][]Pixel {
 	bins := make([][]Pixel, levels*levels*levels)
-	for y := 0; y < len(src); y++ {
-		row := src[y]
+	for y := 0; y < src.Height; y++ {
+		row := src.row(y)
 		for x := 0; x < len(row); x++ {
 			p := row[x]
 			bin := quantizePixel(p, levels)
@@ -221,10 +254,10 @@ func buildSourceBins(src [][]Pixel, levels int) [][]Pixel {
 }
 
 // buildTargetHistogram counts how many pixels of the target fall into each bin.
-func buildTargetHistogram(target [][]Pixel, levels int) []int {
+func buildTargetHistogram(target *PixelBuffer, levels int) []int {
 	hist := make([]int, levels*levels*levels)
-	for y := 0; y < len(target); y++ {
-		row := target[y]
+	for y := 0; y < target.Height; y++ {
+		row := target.row(y)
 		for x := 0; x < len(row); x++ {
 			bin := quantizePixel(row[x], levels)
 			hist[bin]++
@@ -233,82 +266,161 @@ func buildTargetHistogram(target [][]Pixel, levels int) []int {
 	return hist
 }
 
-// popPixel removes and returns one pixel from the requested bin; if empty, it finds
-// the nearest bin (in quantized color space) that still has supply. Returns false if
-// no pixel is available (should not happen when images have identical pixel counts).
-func popPixel(bin int, bins [][]Pixel, levels int) (Pixel, bool) {
-	if len(bins[bin]) > 0 {
-		last := bins[bin][len(bins[bin])-1]
-		bins[bin] = bins[bin][:len(bins[bin])-1]
-		return last, true
-	}
+// remapOptions décrit un remap : les pixels sources sont déplacés (jamais
+// modifiés) pour reproduire la distribution de couleurs de la cible.
+type remapOptions struct {
+	// Method : "bins" (chaque position reçoit un pixel de son bin de couleur,
+	// ou du bin non vide le plus proche) ou "sliced" (transport optimal
+	// approché, sans bins : écart de couleur total plus faible mais plus
+	// lent, voir transportRemap).
+	Method string
+	// Levels est le nombre de bins par canal (ex: 16 -> 4096 bins, "bins").
+	Levels int
+	// Distance est la clé de colorMetrics utilisée pour chercher le bin non
+	// vide le plus proche quand le bin demandé est épuisé ("bins"). Elle ne
+	// choisit que ce bin de repli : une distance perceptuelle ne réduit pas
+	// l'écart perceptuel du résultat (CompareRemapDistances).
+	Distance string
+	// Iterations est le nombre d'itérations du transport "sliced" (0 :
+	// appariement direct le long d'une courbe de Hilbert).
+	Iterations int
+	// RefinePasses est le nombre de passes d'échanges de pixels après le
+	// remap (0 : aucun affinage, voir refineRemap).
+	RefinePasses int
+	// RefineSigma est l'écart type du flou appliqué à la cible de
+	// l'affinage (0 : cible nette).
+	RefineSigma float64
+	// Seed initialise le mélange des pixels de chaque bin ("bins"), les
+	// directions et l'ordre des positions de même couleur ("sliced") et les
+	// échanges de l'affinage : mêmes images et même graine, même résultat (en
+	// séquentiel comme en parallèle).
+	Seed int64
+}
 
-	targetCR, targetCG, targetCB := binCenter(bin, levels)
-	bestIdx := -1
-	bestDist := int(^uint(0) >> 1) // max int
+// defaultRemapOptions : bins, 16 niveaux par canal, distance RGB, graine 1,
+// sans affinage ; 20 itérations pour "sliced".
+var defaultRemapOptions = remapOptions{Method: "bins", Levels: 16, Distance: "rgb", Iterations: 20, Seed: 1}
 
-	for idx, supply := range bins {
-		if len(supply) == 0 {
-			continue
-		}
-		cR, cG, cB := binCenter(idx, levels)
-		dist := sqDist(targetCR, targetCG, targetCB, cR, cG, cB)
-		if dist < bestDist {
-			bestDist = dist
-			bestIdx = idx
-		}
-	}
+// remapMethods liste les méthodes acceptées par remapOptions.Method.
+var remapMethods = []string{"bins", "sliced"}
 
-	if bestIdx == -1 {
-		return Pixel{}, false
-	}
+// maxRemapLevels limite le nombre de bins (64 -> 262144 bins)
+const maxRemapLevels = 64
 
-	last := bins[bestIdx][len(bins[bestIdx])-1]
-	bins[bestIdx] = bins[bestIdx][:len(bins[bestIdx])-1]
-	return last, true
+// checkRemap retourne une erreur si les options sont invalides
+func checkRemap(opts remapOptions) error {
+	if !slices.Contains(remapMethods, opts.Method) {
+		return fmt.Errorf("méthode de remap inconnue %q", opts.Method)
+	}
+	if opts.Levels < 2 || opts.Levels > maxRemapLevels {
+		return fmt.Errorf("niveaux par canal %d hors de [2, %d]", opts.Levels, maxRemapLevels)
+	}
+	if _, ok := colorMetrics[opts.Distance]; !ok {
+		return fmt.Errorf("distance inconnue %q", opts.Distance)
+	}
+	if err := checkSliced(opts.Iterations); err != nil {
+		return err
+	}
+	return checkRefine(opts.RefinePasses, opts.RefineSigma)
 }
 
 // remapPixels rearranges source pixels to match the target color distribution.
 // Assumptions: src and target have identical dimensions. No pixel value is changed.
-// levels controls the number of bins per channel (e.g., 16 -> 4096 bins).
-// Pixels are placed in a randomized order to distribute source pixels uniformly.
-func remapPixels(src [][]Pixel, target [][]Pixel, levels int) [][]Pixel {
-	if len(src) == 0 || len(target) == 0 || len(src) != len(target) || len(src[0]) != len(target[0]) {
-		return nil
-	}
-
-	height := len(target)
-	width := len(target[0])
+// opts controls the number of bins per channel and the distance between bins;
+// nil when the options are invalid.
+// The pixels assigned to a bin are shuffled before placement, to spread
+// source pixels uniformly over the bin's positions; the shuffle depends
+// only on opts.Seed.
+func remapPixels(src, target *PixelBuffer, opts remapOptions) *PixelBuffer {
+	return remap(src, target, opts, nil)
+}
 
-	bins := buildSourceBins(src, levels)
+// remapBand est un morceau de lignes de la cible, avec le bin de chacune de
+// ses positions et le rang, dans chaque bin, de sa première position du bin
+type remapBand struct {
+	start, end int
+	bins       []int32
+	first      []int32
+}
 
-	out := make([][]Pixel, height)
-	for y := 0; y < height; y++ {
-		out[y] = make([]Pixel, width)
+// remap est l'implémentation commune : exec nil = séquentiel. Remap selon
+// opts.Method, puis affinage éventuel (refineRemap) ; le résultat est le
+// même quel que soit exec.
+func remap(src, target *PixelBuffer, opts remapOptions, exec *Executor) *PixelBuffer {
+	if checkRemap(opts) != nil || src.empty() || target.empty() || !src.sameSize(target) {
+		return nil
 	}
+	var out *PixelBuffer
+	if opts.Method == "sliced" {
+		out = transportRemap(src, target, opts, exec)
+	} else {
+		out = binRemap(src, target, opts, exec)
+	}
+	return refineRemap(out, target, opts, exec)
+}
 
-	positions := make([][2]int, height*width)
-	idx := 0
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			positions[idx] = [2]int{x, y}
-			idx++
+// binRemap est le remap "bins". Les pixels sont d'abord attribués aux bins de
+// la cible (binSupply.plan), puis chaque bande de lignes place les pixels de
+// ses positions sans verrou : la k-ième position d'un bin, dans l'ordre de
+// lecture, reçoit le k-ième pixel attribué au bin. Les rangs ne dépendent pas
+// du découpage en bandes, ni le mélange (un générateur par bin, initialisé
+// par la graine et l'index du bin).
+func binRemap(src, target *PixelBuffer, opts remapOptions, exec *Executor) *PixelBuffer {
+	width := target.Width
+	levels := opts.Levels
+	numBins := levels * levels * levels
+
+	// Bin de chaque position de la cible, et comptes par bande
+	var bands []*remapBand
+	var mu sync.Mutex
+	runRows(exec, target.Height, func(start, end int) {
+		band := &remapBand{start: start, end: end, bins: make([]int32, (end-start)*width), first: make([]int32, numBins)}
+		for y := start; y < end; y++ {
+			for x, t := range target.row(y) {
+				bin := quantizePixel(t, levels)
+				band.bins[(y-start)*width+x] = int32(bin)
+				band.first[bin]++
+			}
 		}
-	}
-	rand.Shuffle(len(positions), func(i, j int) {
-		positions[i], positions[j] = positions[j], positions[i]
+		mu.Lock()
+		bands = append(bands, band)
+		mu.Unlock()
 	})
 
-	for _, pos := range positions {
-		x, y := pos[0], pos[1]
-		bin := quantizePixel(target[y][x], levels)
-		p, ok := popPixel(bin, bins, levels)
-		if !ok {
-			out[y][x] = target[y][x]
-			continue
+	// Demande de chaque bin ; les comptes des bandes deviennent des rangs
+	slices.SortFunc(bands, func(a, b *remapBand) int { return a.start - b.start })
+	demand := make([]int, numBins)
+	for _, band := range bands {
+		for bin, n := range band.first {
+			band.first[bin] = int32(demand[bin])
+			demand[bin] += int(n)
 		}
-		out[y][x] = p
 	}
 
+	plan := newBinSupply(src, levels, opts.Distance).plan(demand)
+	runRows(exec, numBins, func(start, end int) {
+		source := rand.NewPCG(0, 0)
+		rng := rand.New(source)
+		for bin, pixels := range plan[start:end] {
+			source.Seed(uint64(opts.Seed), uint64(start+bin))
+			rng.Shuffle(len(pixels), func(i, j int) { pixels[i], pixels[j] = pixels[j], pixels[i] })
+		}
+	})
+
+	out := newPixelBuffer(width, target.Height)
+	runRows(exec, len(bands), func(start, end int) {
+		for _, band := range bands[start:end] {
+			rank := band.first
+			for y := band.start; y < band.end; y++ {
+				row := out.row(y)
+				// src et target ont autant de pixels : plan sert toute la
+				// demande, et chaque rang a son pixel
+				for x, bin := range band.bins[(y-band.start)*width : (y-band.start+1)*width] {
+					row[x] = plan[bin][rank[bin]]
+					rank[bin]++
+				}
+			}
+		}
+	})
 	return out
 }
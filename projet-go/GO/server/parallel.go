@@ -1,264 +1,195 @@
 package main
 
-import (
-	"image"
-	"math/rand"
-	"runtime"
-	"sync"
-)
+import "image"
 
-// extractPixelsParallel convertit une image en matrice de pixels RGB (parallèle)
-func extractPixelsParallel(m image.Image, width, height int) [][]Pixel {
-	rgbMatrix := make([][]Pixel, height)
-	for y := 0; y < height; y++ {
-		rgbMatrix[y] = make([]Pixel, width)
+// extractPixelsParallel convertit une image en buffer de pixels RGB (parallèle)
+func extractPixelsParallel(m image.Image, width, height int) *PixelBuffer {
+	buf := newPixelBuffer(width, height)
+	if buf.empty() {
+		return buf
 	}
 
-	// Guard against empty images
-	if height == 0 || width == 0 {
-		return rgbMatrix
-	}
-
-	// Don't spawn more workers than rows: clamp to height
-	numWorkers := runtime.NumCPU()
-	if numWorkers > height {
-		numWorkers = height
-	}
-
-	chunkSize := height / numWorkers
-	// Ensure at least one row per worker
-	if chunkSize == 0 {
-		chunkSize = 1
-	}
-
-	var wg sync.WaitGroup
-	for i := 0; i < numWorkers; i++ {
-		startY := i * chunkSize
-		endY := startY + chunkSize
-		// Clamp endY to avoid out of range when chunk distribution doesn't cover all rows
-		if endY > height {
-			endY = height
+	extractRow := rowExtractor(m)
+	parallelExec.Rows(height, func(start, end int) {
+		for y := start; y < end; y++ {
+			extractRow(y, buf.row(y))
 		}
-		if i == numWorkers-1 {
-			endY = height
-		}
-
-		wg.Add(1)
-		go func(start, end int) {
-			defer wg.Done()
-			for y := start; y < end; y++ {
-				for x := 0; x < width; x++ {
-					r, g, b, _ := m.At(x, y).RGBA()
-					rgbMatrix[y][x] = Pixel{R: uint16(r), G: uint16(g), B: uint16(b)}
-				}
-			}
-		}(startY, endY)
-	}
-
-	wg.Wait()
-	return rgbMatrix
+	})
+	return buf
 }
 
-// blackWhiteParallel convertit la matrice en niveaux de gris (parallèle)
-func blackWhiteParallel(rgbMatrix [][]Pixel, width, height int) [][]Pixel {
-	// Guard against empty input
-	if height == 0 || width == 0 {
-		return rgbMatrix
-	}
-
-	numGoroutines := runtime.NumCPU()
-	if numGoroutines > height {
-		numGoroutines = height
-	}
-	if numGoroutines == 0 {
-		return rgbMatrix
+// blackWhiteParallel convertit le buffer en niveaux de gris (parallèle)
+func blackWhiteParallel(buf *PixelBuffer) *PixelBuffer {
+	if buf.empty() {
+		return buf
 	}
 
-	rowsPerGoroutine := (height + numGoroutines - 1) / numGoroutines
-
-	var wg sync.WaitGroup
-	wg.Add(numGoroutines)
-
-	for g := 0; g < numGoroutines; g++ {
-		go func(goroutineID int) {
-			defer wg.Done()
-
-			startRow := goroutineID * rowsPerGoroutine
-			endRow := (goroutineID + 1) * rowsPerGoroutine
-			if endRow > height {
-				endRow = height
+	parallelExec.Rows(buf.Height, func(start, end int) {
+		for y := start; y < end; y++ {
+			row := buf.row(y)
+			for x := range row {
+				row[x] = grayPixel(row[x])
 			}
+		}
+	})
+	return buf
+}
 
-			for y := startRow; y < endRow; y++ {
-				for x := 0; x < width; x++ {
-					p := rgbMatrix[y][x]
-					gray := uint16(0.299*float64(p.R) + 0.587*float64(p.G) + 0.114*float64(p.B))
-					rgbMatrix[y][x] = Pixel{R: gray, G: gray, B: gray}
-				}
-			}
-		}(g)
+// downscalePixelsParallel réduit la définition sans changer la taille (parallèle)
+func downscalePixelsParallel(buf *PixelBuffer, factor int) *PixelBuffer {
+	if factor <= 1 || buf.empty() {
+		return buf
 	}
 
-	wg.Wait()
-	return rgbMatrix
+	result := newPixelBuffer(buf.Width, buf.Height)
+	// Partage par rangées de blocs : aucun bloc n'est coupé entre deux workers,
+	// le résultat est identique à downscalePixels.
+	parallelExec.Rows(blockCount(buf.Height, factor), func(start, end int) {
+		downscaleBlockRows(buf, result, factor, false, start, end)
+	})
+	return result
 }
 
-// downscalePixels réduit la définition sans changer la taille (parallèle)
-func downscalePixelsParallel(rgbMatrix [][]Pixel, width, height, factor int) [][]Pixel {
-	if factor <= 1 {
-		return rgbMatrix
-	}
-	if len(rgbMatrix) == 0 || len(rgbMatrix[0]) == 0 {
-		return rgbMatrix
+// downscalePixelsLinearParallel pixelise en lumière linéaire (parallèle). Le
+// résultat est identique à downscalePixelsLinear.
+func downscalePixelsLinearParallel(buf *PixelBuffer, factor int) *PixelBuffer {
+	if factor <= 1 || buf.empty() {
+		return buf
 	}
 
-	result := make([][]Pixel, height)
-	for y := 0; y < height; y++ {
-		result[y] = make([]Pixel, width)
-	}
+	result := newPixelBuffer(buf.Width, buf.Height)
+	parallelExec.Rows(blockCount(buf.Height, factor), func(start, end int) {
+		downscaleBlockRows(buf, result, factor, true, start, end)
+	})
+	return result
+}
 
-	// Diviser le travail par lignes
-	if height == 0 || width == 0 {
-		return result
-	}
+// remapPixelsParallel part d'une matrice de pixel source et reconstitue une image target.
+// Seule l'attribution des pixels aux bins est séquentielle (une opération par
+// bin) : le calcul des bins de la cible et le placement des pixels se font par
+// bandes, sans verrou.
+func remapPixelsParallel(src, target *PixelBuffer, opts remapOptions) *PixelBuffer {
+	return remap(src, target, opts, &parallelExec)
+}
 
-	numGoroutines := runtime.NumCPU()
-	if numGoroutines > height {
-		numGoroutines = height
-	}
-	if numGoroutines == 0 {
-		return result
+// resizePixelsParallel redimensionne l'image en dstW x dstH (parallèle).
+// Chaque passe est répartie par lignes : le résultat est identique à resizePixels.
+func resizePixelsParallel(buf *PixelBuffer, dstW, dstH int, k resampleKernel) *PixelBuffer {
+	if buf.empty() || dstW <= 0 || dstH <= 0 {
+		return newPixelBuffer(max(dstW, 0), max(dstH, 0))
 	}
+	s := newResizeState(buf, dstW, dstH, k)
+	parallelExec.Rows(buf.Height, s.horizontalRows)
+	parallelExec.Rows(dstH, s.verticalRows)
+	return s.dst
+}
 
-	rowsPerGoroutine := (height + numGoroutines - 1) / numGoroutines
-
-	var wg sync.WaitGroup
-	wg.Add(numGoroutines)
-
-	for g := 0; g < numGoroutines; g++ {
-		go func(goroutineID int) {
-			defer wg.Done()
-
-			startRow := goroutineID * rowsPerGoroutine
-			endRow := (goroutineID + 1) * rowsPerGoroutine
-			if endRow > height {
-				endRow = height
-			}
-
-			// Traiter les blocs dans cette tranche de lignes
-			for by := startRow; by < endRow; by += factor {
-				for bx := 0; bx < width; bx += factor {
-					var sumR, sumG, sumB uint64
-					count := 0
-					maxY := by + factor
-					if maxY > height {
-						maxY = height
-					}
-					maxX := bx + factor
-					if maxX > width {
-						maxX = width
-					}
-
-					// Calculer la moyenne du bloc
-					for y := by; y < maxY; y++ {
-						for x := bx; x < maxX; x++ {
-							p := rgbMatrix[y][x]
-							sumR += uint64(p.R)
-							sumG += uint64(p.G)
-							sumB += uint64(p.B)
-							count++
-						}
-					}
-
-					avg := Pixel{
-						R: uint16(sumR / uint64(count)),
-						G: uint16(sumG / uint64(count)),
-						B: uint16(sumB / uint64(count)),
-					}
-
-					// Remplir le bloc avec la moyenne
-					for y := by; y < maxY; y++ {
-						for x := bx; x < maxX; x++ {
-							result[y][x] = avg
-						}
-					}
-				}
-			}
-		}(g)
+// upscalePixelsParallel agrandit l'image d'un facteur entier (parallèle).
+// Retourne nil si checkUpscale refuse la méthode ou le facteur.
+func upscalePixelsParallel(buf *PixelBuffer, factor int, method string) *PixelBuffer {
+	if checkUpscale(method, factor) != nil {
+		return nil
 	}
-
-	wg.Wait()
-	return result
+	switch method {
+	case "scale2x":
+		for ; factor > 1; factor /= 2 {
+			src, dst := buf, newPixelBuffer(2*buf.Width, 2*buf.Height)
+			parallelExec.Rows(src.Height, func(start, end int) {
+				scale2xRows(src, dst, start, end)
+			})
+			buf = dst
+		}
+		return buf
+	case "scale3x":
+		for ; factor > 1; factor /= 3 {
+			src, dst := buf, newPixelBuffer(3*buf.Width, 3*buf.Height)
+			parallelExec.Rows(src.Height, func(start, end int) {
+				scale3xRows(src, dst, start, end)
+			})
+			buf = dst
+		}
+		return buf
+	}
+	k, _ := kernelByName(method)
+	return resizePixelsParallel(buf, factor*buf.Width, factor*buf.Height, k)
 }
 
-// remapPixels part d'une matrice de pixel source et reconstitue une image target
-func remapPixelsParallel(src [][]Pixel, target [][]Pixel, levels int) [][]Pixel {
-	if len(src) == 0 || len(target) == 0 || len(src) != len(target) || len(src[0]) != len(target[0]) {
+// grayscalePixelsParallel convertit le buffer en niveaux de gris selon opts
+// (parallèle, in-place). Retourne nil si les options sont invalides.
+func grayscalePixelsParallel(buf *PixelBuffer, opts grayOptions) *PixelBuffer {
+	convert, err := grayConverter(opts)
+	if err != nil {
 		return nil
 	}
+	parallelExec.Rows(buf.Height, func(start, end int) {
+		for y := start; y < end; y++ {
+			row := buf.row(y)
+			for x := range row {
+				row[x] = convert(row[x])
+			}
+		}
+	})
+	return buf
+}
 
-	height := len(target)
-	width := len(target[0])
+// binarizePixelsParallel convertit l'image en noir et blanc 1 bit (parallèle).
+// Le résultat est identique à binarizePixels pour toutes les méthodes, tramages
+// par diffusion d'erreur compris (traitement des lignes en front d'onde).
+func binarizePixelsParallel(buf *PixelBuffer, opts bilevelOptions) *PixelBuffer {
+	return binarize(buf, opts, &parallelExec)
+}
 
-	// Supply: group all source pixels by bin.
-	bins := buildSourceBins(src, levels)
+// quantizeImageParallel réduit l'image à une palette (parallèle). Histogramme,
+// k-means et affectation des pixels sont répartis par bandes ; le tramage est
+// traité en front d'onde. Le résultat est identique à quantizeImage.
+func quantizeImageParallel(buf *PixelBuffer, opts quantizeOptions) *image.Paletted {
+	return quantize(buf, opts, &parallelExec)
+}
 
-	// Output image (same dimensions).
-	out := make([][]Pixel, height)
-	for y := 0; y < height; y++ {
-		out[y] = make([]Pixel, width)
-	}
+// paletteMapImageParallel remplace chaque pixel par la couleur la plus proche
+// de la palette imposée (parallèle). Le résultat est identique à paletteMapImage.
+func paletteMapImageParallel(buf *PixelBuffer, opts paletteOptions) *image.Paletted {
+	return paletteMap(buf, opts, &parallelExec)
+}
 
-	// Create a randomized order of target positions to avoid bias.
-	positions := make([][2]int, height*width)
-	idx := 0
-	for y := 0; y < height; y++ {
-		for x := 0; x < width; x++ {
-			positions[idx] = [2]int{x, y}
-			idx++
-		}
-	}
-	rand.Shuffle(len(positions), func(i, j int) { positions[i], positions[j] = positions[j], positions[i] })
+// convolvePixelsParallel applique le noyau k à l'image (parallèle, par bandes
+// de lignes). Le résultat est identique à convolvePixels.
+func convolvePixelsParallel(buf *PixelBuffer, k convKernel, edge string) *PixelBuffer {
+	return convolve(buf, k, edge, &parallelExec)
+}
 
-	// Feed positions into a buffered channel consumed by workers.
-	posCh := make(chan [2]int, len(positions))
-	for _, p := range positions {
-		posCh <- p
-	}
-	close(posCh)
+// filterPixelsParallel applique le filtre décrit par opts (parallèle).
+// Le résultat est identique à filterPixels.
+func filterPixelsParallel(buf *PixelBuffer, opts filterOptions) *PixelBuffer {
+	return filter(buf, opts, &parallelExec)
+}
 
-	var mu sync.Mutex // protects access to bins and popPixel
-	workers := runtime.NumCPU()
-	// no need to start more workers than positions
-	if workers > len(positions) {
-		workers = len(positions)
-	}
-	if workers == 0 {
-		return out
-	}
-	var wg sync.WaitGroup
-	wg.Add(workers)
+// edgePixelsParallel calcule la carte des contours (parallèle).
+// Le résultat est identique à edgePixels.
+func edgePixelsParallel(buf *PixelBuffer, opts edgeOptions) *PixelBuffer {
+	return detectEdges(buf, opts, &parallelExec)
+}
 
-	for i := 0; i < workers; i++ {
-		go func() {
-			defer wg.Done()
-			for pos := range posCh {
-				x, y := pos[0], pos[1]
-				bin := quantizePixel(target[y][x], levels)
+// denoisePixelsParallel débruite l'image (parallèle, par bandes de lignes).
+// Le résultat est identique à denoisePixels.
+func denoisePixelsParallel(buf *PixelBuffer, opts denoiseOptions) *PixelBuffer {
+	return denoise(buf, opts, &parallelExec)
+}
 
-				mu.Lock()
-				p, ok := popPixel(bin, bins, levels)
-				mu.Unlock()
+// adjustPixelsParallel applique le réglage tonal (parallèle, in-place).
+// Le résultat est identique à adjustPixels.
+func adjustPixelsParallel(buf *PixelBuffer, opts adjustOptions) *PixelBuffer {
+	return adjust(buf, opts, &parallelExec)
+}
 
-				if !ok {
-					out[y][x] = target[y][x]
-					continue
-				}
-				out[y][x] = p
-			}
-		}()
-	}
+// equalizePixelsParallel améliore le contraste de l'image (parallèle,
+// in-place). Le résultat est identique à equalizePixels.
+func equalizePixelsParallel(buf *PixelBuffer, opts equalizeOptions) *PixelBuffer {
+	return equalize(buf, opts, &parallelExec)
+}
 
-	wg.Wait()
-	return out
+// matchPixelsParallel donne à src la distribution de couleurs de target
+// (parallèle). Le résultat est identique à matchPixels.
+func matchPixelsParallel(src, target *PixelBuffer, opts matchOptions) *PixelBuffer {
+	return match(src, target, opts, &parallelExec)
 }
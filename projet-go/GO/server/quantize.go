@@ -0,0 +1,456 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"image"
+	"image/color"
+	"slices"
+	"sync"
+)
+
+// quantizeOptions décrit une réduction de l'image à une palette de couleurs.
+type quantizeOptions struct {
+	// Method : "uniform" (grille régulière de quantizePixel, posterisation),
+	// "mediancut", "octree" ou "kmeans" (palettes adaptées à l'image).
+	Method string
+	// Colors est le nombre maximal de couleurs des palettes adaptées (2 à 256).
+	Colors int
+	// Levels est le nombre de niveaux par canal de "uniform" (2 à 6, soit
+	// Levels^3 <= 256 couleurs).
+	Levels int
+	// Iterations est le nombre maximal d'itérations de "kmeans".
+	Iterations int
+	// Dither : "none", ou un noyau de diffusion d'erreur de diffusionKernels
+	// ("floyd-steinberg", "atkinson").
+	Dither string
+}
+
+// defaultQuantizeOptions : 16 couleurs par median cut, sans tramage.
+var defaultQuantizeOptions = quantizeOptions{
+	Method:     "mediancut",
+	Colors:     16,
+	Levels:     4,
+	Iterations: 10,
+	Dither:     "none",
+}
+
+// quantizeMethods liste les méthodes acceptées par quantizeOptions.Method.
+var quantizeMethods = []string{"uniform", "mediancut", "octree", "kmeans"}
+
+// histogramLevels est le nombre de niveaux par canal de l'histogramme sur
+// lequel sont calculées les palettes adaptées (32 -> 32768 bins).
+const histogramLevels = 32
+
+// checkQuantize retourne une erreur si les options sont invalides
+func checkQuantize(opts quantizeOptions) error {
+	switch opts.Method {
+	case "uniform":
+		if opts.Levels < 2 || opts.Levels > 6 {
+			return fmt.Errorf("nombre de niveaux %d hors de [2, 6]", opts.Levels)
+		}
+	case "mediancut", "octree", "kmeans":
+		if opts.Colors < 2 || opts.Colors > 256 {
+			return fmt.Errorf("nombre de couleurs %d hors de [2, 256]", opts.Colors)
+		}
+		if opts.Method == "kmeans" && opts.Iterations < 1 {
+			return fmt.Errorf("nombre d'itérations %d invalide (>= 1)", opts.Iterations)
+		}
+	default:
+		return fmt.Errorf("méthode de quantification inconnue %q", opts.Method)
+	}
+	return checkDither(opts.Dither)
+}
+
+// checkDither retourne une erreur si dither n'est ni "none" ni un noyau de diffusionKernels
+func checkDither(dither string) error {
+	if _, ok := diffusionKernels[dither]; !ok && dither != "none" {
+		return fmt.Errorf("tramage inconnu %q", dither)
+	}
+	return nil
+}
+
+// quantizeImage réduit l'image à une palette (séquentiel). Retourne une image
+// palettisée (png.Encode et gif.Encode écrivent directement sa palette), ou
+// nil si les options sont invalides.
+func quantizeImage(buf *PixelBuffer, opts quantizeOptions) *image.Paletted {
+	return quantize(buf, opts, nil)
+}
+
+// quantize est l'implémentation commune : exec nil = séquentiel, sinon les
+// étapes sont réparties par exec
+func quantize(buf *PixelBuffer, opts quantizeOptions, exec *Executor) *image.Paletted {
+	if checkQuantize(opts) != nil {
+		return nil
+	}
+
+	var palette []Pixel
+	var nearest func(p Pixel) int
+	if opts.Method == "uniform" {
+		// Grille régulière : l'index de palette est directement le bin
+		levels := opts.Levels
+		palette = make([]Pixel, levels*levels*levels)
+		for i := range palette {
+			r, g, b := binCenter(i, levels)
+			palette[i] = Pixel{R: uint16(r), G: uint16(g), B: uint16(b)}
+		}
+		nearest = func(p Pixel) int { return quantizePixel(p, levels) }
+	} else {
+		hist := colorHistogram(buf, exec)
+		switch opts.Method {
+		case "mediancut":
+			palette = medianCutPalette(hist, opts.Colors)
+		case "octree":
+			palette = octreePalette(hist, opts.Colors)
+		case "kmeans":
+			palette = kmeansPalette(hist, opts.Colors, opts.Iterations, exec)
+		}
+		nearest = func(p Pixel) int { return nearestColor(p, palette) }
+	}
+
+	return mapToPalette(buf, palette, nearest, opts.Dither, exec)
+}
+
+// mapToPalette construit l'image palettisée de buf : chaque pixel reçoit
+// l'index nearest(p), avec tramage par diffusion d'erreur si dither est un
+// noyau de diffusionKernels ("none" : pas de tramage).
+func mapToPalette(buf *PixelBuffer, palette []Pixel, nearest func(p Pixel) int, dither string, exec *Executor) *image.Paletted {
+	colors := make(color.Palette, len(palette))
+	for i, p := range palette {
+		colors[i] = color.RGBA64{R: p.R, G: p.G, B: p.B, A: 0xffff}
+	}
+	img := image.NewPaletted(buf.Bounds(), colors)
+
+	if k, ok := diffusionKernels[dither]; ok {
+		ditherPalette(buf, img, palette, nearest, k, exec)
+		return img
+	}
+	rows := func(start, end int) {
+		for y := start; y < end; y++ {
+			line := img.Pix[y*img.Stride : y*img.Stride+buf.Width]
+			for x, p := range buf.row(y) {
+				line[x] = uint8(nearest(p))
+			}
+		}
+	}
+	runRows(exec, buf.Height, rows)
+	return img
+}
+
+// nearestColor retourne l'index de la couleur de palette la plus proche de p
+func nearestColor(p Pixel, palette []Pixel) int {
+	best, bestDist := 0, int(^uint(0)>>1)
+	for i, c := range palette {
+		d := sqDist(int(p.R), int(p.G), int(p.B), int(c.R), int(c.G), int(c.B))
+		if d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// colorBin cumule les pixels d'un bin de l'histogramme couleur
+type colorBin struct {
+	sumR, sumG, sumB uint64
+	count            uint64
+	index            int // index du bin (quantizePixel avec histogramLevels)
+}
+
+// mean retourne la couleur moyenne des pixels du bin
+func (b colorBin) mean() Pixel {
+	return Pixel{R: uint16(b.sumR / b.count), G: uint16(b.sumG / b.count), B: uint16(b.sumB / b.count)}
+}
+
+// add cumule o dans b
+func (b *colorBin) add(o colorBin) {
+	b.sumR += o.sumR
+	b.sumG += o.sumG
+	b.sumB += o.sumB
+	b.count += o.count
+}
+
+// colorHistogram retourne les bins non vides de l'histogramme couleur de
+// l'image (histogramLevels niveaux par canal), par index croissant. Les sommes
+// sont entières : la version parallèle donne le même histogramme.
+func colorHistogram(buf *PixelBuffer, exec *Executor) []colorBin {
+	bins := make([]colorBin, histogramLevels*histogramLevels*histogramLevels)
+	accumulate := func(dst []colorBin, start, end int) {
+		for y := start; y < end; y++ {
+			for _, p := range buf.row(y) {
+				b := &dst[quantizePixel(p, histogramLevels)]
+				b.sumR += uint64(p.R)
+				b.sumG += uint64(p.G)
+				b.sumB += uint64(p.B)
+				b.count++
+			}
+		}
+	}
+	if exec == nil {
+		accumulate(bins, 0, buf.Height)
+	} else {
+		// Histogrammes partiels par bande, fusionnés ensuite
+		var mu sync.Mutex
+		exec.Rows(buf.Height, func(start, end int) {
+			local := make([]colorBin, len(bins))
+			accumulate(local, start, end)
+			mu.Lock()
+			for i := range local {
+				bins[i].add(local[i])
+			}
+			mu.Unlock()
+		})
+	}
+
+	var used []colorBin
+	for i, b := range bins {
+		if b.count > 0 {
+			b.index = i
+			used = append(used, b)
+		}
+	}
+	return used
+}
+
+// channel retourne le canal c (0 = R, 1 = G, 2 = B) de p
+func (p Pixel) channel(c int) uint16 {
+	switch c {
+	case 0:
+		return p.R
+	case 1:
+		return p.G
+	}
+	return p.B
+}
+
+// medianCutPalette calcule une palette d'au plus n couleurs par median cut :
+// la boîte dont un canal a la plus grande étendue est coupée en deux à la
+// médiane (en nombre de pixels) de ce canal, jusqu'à obtenir n boîtes. Chaque
+// boîte donne la couleur moyenne de ses pixels.
+func medianCutPalette(hist []colorBin, n int) []Pixel {
+	if len(hist) == 0 {
+		return []Pixel{{}}
+	}
+	boxes := [][]colorBin{slices.Clone(hist)}
+	for len(boxes) < n {
+		// Boîte (et canal) de plus grande étendue
+		best, bestChannel, bestRange := -1, 0, -1
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			for c := 0; c < 3; c++ {
+				lo, hi := uint16(65535), uint16(0)
+				for _, b := range box {
+					v := b.mean().channel(c)
+					lo, hi = min(lo, v), max(hi, v)
+				}
+				if int(hi-lo) > bestRange {
+					best, bestChannel, bestRange = i, c, int(hi-lo)
+				}
+			}
+		}
+		if best < 0 {
+			break // plus aucune boîte divisible
+		}
+
+		box := boxes[best]
+		slices.SortFunc(box, func(a, b colorBin) int {
+			if d := cmp.Compare(a.mean().channel(bestChannel), b.mean().channel(bestChannel)); d != 0 {
+				return d
+			}
+			return cmp.Compare(a.index, b.index)
+		})
+		var total, acc uint64
+		for _, b := range box {
+			total += b.count
+		}
+		split := 1
+		for i, b := range box[:len(box)-1] {
+			acc += b.count
+			if 2*acc >= total {
+				split = i + 1
+				break
+			}
+		}
+		boxes[best] = box[:split]
+		boxes = append(boxes, box[split:])
+	}
+
+	palette := make([]Pixel, len(boxes))
+	for i, box := range boxes {
+		var sum colorBin
+		for _, b := range box {
+			sum.add(b)
+		}
+		palette[i] = sum.mean()
+	}
+	return palette
+}
+
+// octreeNode est un nœud de l'octree : les enfants sont indexés par un bit de
+// chaque canal ; une feuille cumule les pixels de son sous-arbre.
+type octreeNode struct {
+	children [8]*octreeNode
+	leaf     bool
+	pixels   colorBin
+}
+
+// octreeDepth est la profondeur des feuilles : un niveau par bit des
+// coordonnées de bin de l'histogramme (32 = 2^5 niveaux par canal).
+const octreeDepth = 5
+
+// octreePalette calcule une palette d'au plus n couleurs par octree : les bins
+// de l'histogramme sont insérés comme feuilles, puis les nœuds les plus
+// profonds sont fusionnés avec leurs enfants, les moins peuplés d'abord,
+// jusqu'à ne plus avoir que n feuilles.
+func octreePalette(hist []colorBin, n int) []Pixel {
+	if len(hist) == 0 {
+		return []Pixel{{}}
+	}
+	root := &octreeNode{}
+	levels := make([][]*octreeNode, octreeDepth) // nœuds internes par profondeur
+	levels[0] = []*octreeNode{root}
+	leaves := 0
+	for _, b := range hist {
+		qR := b.index / (histogramLevels * histogramLevels)
+		qG := b.index / histogramLevels % histogramLevels
+		qB := b.index % histogramLevels
+		node := root
+		node.pixels.add(b)
+		for depth := 0; depth < octreeDepth; depth++ {
+			shift := octreeDepth - 1 - depth
+			child := (qR>>shift&1)<<2 | (qG>>shift&1)<<1 | qB>>shift&1
+			if node.children[child] == nil {
+				node.children[child] = &octreeNode{}
+				if depth+1 < octreeDepth {
+					levels[depth+1] = append(levels[depth+1], node.children[child])
+				} else {
+					node.children[child].leaf = true
+					leaves++
+				}
+			}
+			node = node.children[child]
+			node.pixels.add(b)
+		}
+	}
+
+	// Réduction : les sous-arbres du niveau depth+1 sont déjà des feuilles
+	// quand on traite le niveau depth
+	for depth := octreeDepth - 1; depth >= 0 && leaves > n; depth-- {
+		nodes := levels[depth]
+		slices.SortStableFunc(nodes, func(a, b *octreeNode) int {
+			return cmp.Compare(a.pixels.count, b.pixels.count)
+		})
+		for _, node := range nodes {
+			if leaves <= n {
+				break
+			}
+			merged := 0
+			for i, child := range node.children {
+				if child != nil {
+					merged++
+					node.children[i] = nil
+				}
+			}
+			node.leaf = true
+			leaves -= merged - 1
+		}
+	}
+
+	var palette []Pixel
+	var collect func(node *octreeNode)
+	collect = func(node *octreeNode) {
+		if node.leaf {
+			palette = append(palette, node.pixels.mean())
+			return
+		}
+		for _, child := range node.children {
+			if child != nil {
+				collect(child)
+			}
+		}
+	}
+	collect(root)
+	return palette
+}
+
+// kmeansPalette affine la palette du median cut par l'algorithme de Lloyd
+// (k-means pondéré par le nombre de pixels de chaque bin) : chaque bin est
+// rattaché à la couleur la plus proche, puis chaque couleur devient la moyenne
+// de ses bins, jusqu'à stabilité ou iterations passes.
+func kmeansPalette(hist []colorBin, n, iterations int, exec *Executor) []Pixel {
+	palette := medianCutPalette(hist, n)
+	assign := func(sums []colorBin, start, end int) {
+		for _, b := range hist[start:end] {
+			sums[nearestColor(b.mean(), palette)].add(b)
+		}
+	}
+	for it := 0; it < iterations; it++ {
+		sums := make([]colorBin, len(palette))
+		if exec == nil {
+			assign(sums, 0, len(hist))
+		} else {
+			var mu sync.Mutex
+			exec.Rows(len(hist), func(start, end int) {
+				local := make([]colorBin, len(palette))
+				assign(local, start, end)
+				mu.Lock()
+				for i := range local {
+					sums[i].add(local[i])
+				}
+				mu.Unlock()
+			})
+		}
+
+		changed := false
+		for i, s := range sums {
+			if s.count == 0 {
+				continue // couleur sans pixel : conservée telle quelle
+			}
+			if m := s.mean(); m != palette[i] {
+				palette[i] = m
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+	}
+	return palette
+}
+
+// ditherPalette remplit img en tramant l'image sur la palette par diffusion
+// d'erreur (noyau k), canal par canal. Comme pour diffuseError, les erreurs
+// sont entières et le parallélisme en front d'onde donne le résultat séquentiel.
+func ditherPalette(buf *PixelBuffer, img *image.Paletted, palette []Pixel, nearest func(p Pixel) int, k diffusionKernel, exec *Executor) {
+	width, height := buf.Width, buf.Height
+	values := make([]int32, 3*width*height)
+	for y := 0; y < height; y++ {
+		for x, p := range buf.row(y) {
+			i := 3 * (y*width + x)
+			values[i], values[i+1], values[i+2] = int32(p.R), int32(p.G), int32(p.B)
+		}
+	}
+	clamp := func(v int32) uint16 { return uint16(min(max(v, 0), 65535)) }
+
+	diffusionWavefront(width, height, exec, func(x, y int) {
+		i := 3 * (y*width + x)
+		p := Pixel{R: clamp(values[i]), G: clamp(values[i+1]), B: clamp(values[i+2])}
+		idx := nearest(p)
+		img.Pix[y*img.Stride+x] = uint8(idx)
+		c := palette[idx]
+		errR := int32(p.R) - int32(c.R)
+		errG := int32(p.G) - int32(c.G)
+		errB := int32(p.B) - int32(c.B)
+		for _, t := range k.taps {
+			nx, ny := x+t.dx, y+t.dy
+			if nx < 0 || nx >= width || ny >= height {
+				continue
+			}
+			j := 3 * (ny*width + nx)
+			values[j] += errR * t.weight / k.divisor
+			values[j+1] += errG * t.weight / k.divisor
+			values[j+2] += errB * t.weight / k.divisor
+		}
+	})
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// maxOutputSide limite la taille des images produites (largeur et hauteur)
+const maxOutputSide = 16384
+
+// maxOutputPixels limite leur surface : 16384x16384 pixels passeraient la
+// limite par côté, mais occuperaient plus de 1,5 Go par PixelBuffer
+const maxOutputPixels = 4096 * 4096
+
+// request est une requête client décodée.
+//
+// Format sur la connexion :
+//   - 1 octet : le choix du traitement ;
+//   - une ligne de paramètres "cle=valeur cle=valeur" terminée par '\n'
+//     (vide si le traitement n'a pas de paramètre) ;
+//   - les octets de l'image (JPEG ou PNG) jusqu'à la fin de l'envoi.
+type request struct {
+	choice byte
+	params map[string]string
+	image  []byte
+}
+
+// parseRequest découpe les données reçues en choix, paramètres et image
+func parseRequest(buf []byte) (request, error) {
+	if len(buf) < 2 {
+		return request{}, fmt.Errorf("données insuffisantes")
+	}
+	req := request{choice: buf[0], params: map[string]string{}}
+
+	end := bytes.IndexByte(buf[1:], '\n')
+	if end < 0 {
+		return request{}, fmt.Errorf("ligne de paramètres manquante")
+	}
+	line := string(buf[1 : 1+end])
+	req.image = buf[2+end:]
+
+	for _, field := range strings.Fields(line) {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok || key == "" {
+			return request{}, fmt.Errorf("paramètre invalide %q (attendu cle=valeur)", field)
+		}
+		req.params[key] = value
+	}
+	return req, nil
+}
+
+// has indique si le paramètre a été fourni
+func (r request) has(name string) bool {
+	_, ok := r.params[name]
+	return ok
+}
+
+// stringParam retourne le paramètre name, ou def s'il est absent
+func (r request) stringParam(name, def string) string {
+	if v, ok := r.params[name]; ok {
+		return v
+	}
+	return def
+}
+
+// intParam retourne le paramètre entier name, ou def s'il est absent
+func (r request) intParam(name string, def int) (int, error) {
+	v, ok := r.params[name]
+	if !ok {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("paramètre %s : entier attendu, reçu %q", name, v)
+	}
+	return n, nil
+}
+
+// floatParam retourne le paramètre décimal name, ou def s'il est absent.
+// ParseFloat accepte "NaN" et "Inf", qui passeraient à travers les
+// comparaisons de bornes : seuls les nombres finis sont acceptés.
+func (r request) floatParam(name string, def float64) (float64, error) {
+	v, ok := r.params[name]
+	if !ok {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("paramètre %s : nombre fini attendu, reçu %q", name, v)
+	}
+	return f, nil
+}
+
+// sizeParam lit un paramètre de la forme "LARGEURxHAUTEUR" (ex: 800x600)
+func (r request) sizeParam(name string) (int, int, error) {
+	v := r.params[name]
+	ws, hs, ok := strings.Cut(v, "x")
+	w, errW := strconv.Atoi(ws)
+	h, errH := strconv.Atoi(hs)
+	if !ok || errW != nil || errH != nil || w <= 0 || h <= 0 {
+		return 0, 0, fmt.Errorf("paramètre %s : dimensions LARGEURxHAUTEUR attendues, reçu %q", name, v)
+	}
+	return w, h, nil
+}
+
+// linearParam lit space=srgb|linear : calcul sur les valeurs sRGB (défaut) ou
+// en lumière linéaire
+func linearParam(req request) (bool, error) {
+	switch space := req.stringParam("space", "srgb"); space {
+	case "srgb":
+		return false, nil
+	case "linear":
+		return true, nil
+	default:
+		return false, fmt.Errorf("paramètre space : srgb ou linear attendu, reçu %q", space)
+	}
+}
+
+// downscaleParams lit les options de pixelisation : factor=F (taille des blocs,
+// 4 par défaut) et space=srgb|linear (espace dans lequel les blocs sont moyennés)
+func downscaleParams(req request) (int, bool, error) {
+	factor, err := req.intParam("factor", 4)
+	if err != nil {
+		return 0, false, err
+	}
+	if factor < 1 {
+		return 0, false, fmt.Errorf("facteur %d invalide (>= 1)", factor)
+	}
+	linear, err := linearParam(req)
+	return factor, linear, err
+}
+
+// remapParams lit les options du remap : method=bins|sliced, levels=N (bins
+// par canal, 16 par défaut) et distance=rgb|redmean|cie76|ciede2000 (repli
+// vers le bin le plus proche) pour bins, iterations=N (20 par défaut) pour
+// sliced, refine=N (passes d'échanges après le remap, 0 par défaut) et
+// refine_sigma=S (flou de la cible de l'affinage, 0 par défaut), et seed=N
+// (graine, 1 par défaut : même graine, même image).
+func remapParams(req request) (remapOptions, error) {
+	opts := defaultRemapOptions
+	opts.Method = req.stringParam("method", opts.Method)
+	opts.Distance = req.stringParam("distance", opts.Distance)
+
+	var err error
+	if opts.Levels, err = req.intParam("levels", opts.Levels); err != nil {
+		return opts, err
+	}
+	if opts.Iterations, err = req.intParam("iterations", opts.Iterations); err != nil {
+		return opts, err
+	}
+	if opts.RefinePasses, err = req.intParam("refine", opts.RefinePasses); err != nil {
+		return opts, err
+	}
+	if opts.RefineSigma, err = req.floatParam("refine_sigma", opts.RefineSigma); err != nil {
+		return opts, err
+	}
+	seed, err := req.intParam("seed", int(opts.Seed))
+	if err != nil {
+		return opts, err
+	}
+	opts.Seed = int64(seed)
+	return opts, checkRemap(opts)
+}
+
+// resizeParams calcule la taille cible d'un redimensionnement de w x h à partir
+// des paramètres, dans cet ordre de priorité :
+//   - size=LxH : dimensions exactes ;
+//   - fit=LxH : plus grande taille tenant dans la boîte, proportions conservées ;
+//   - scale=P : P % de la taille d'origine ;
+//   - width=L et/ou height=H : l'autre dimension suit les proportions.
+func resizeParams(req request, w, h int) (int, int, error) {
+	dstW, dstH, err := resizeTarget(req, w, h)
+	if err == nil && (dstW > maxOutputSide || dstH > maxOutputSide) {
+		err = fmt.Errorf("taille cible %dx%d trop grande (max %d)", dstW, dstH, maxOutputSide)
+	}
+	if err == nil && dstW*dstH > maxOutputPixels {
+		err = fmt.Errorf("taille cible %dx%d trop grande (max %d pixels)", dstW, dstH, maxOutputPixels)
+	}
+	return dstW, dstH, err
+}
+
+// resizeTarget applique les règles de resizeParams, sans limite de taille
+func resizeTarget(req request, w, h int) (int, int, error) {
+	switch {
+	case req.has("size"):
+		return req.sizeParam("size")
+	case req.has("fit"):
+		boxW, boxH, err := req.sizeParam("fit")
+		if err != nil {
+			return 0, 0, err
+		}
+		dstW, dstH := fitSize(w, h, boxW, boxH)
+		return dstW, dstH, nil
+	case req.has("scale"):
+		percent, err := req.floatParam("scale", 100)
+		if err != nil {
+			return 0, 0, err
+		}
+		if percent <= 0 {
+			return 0, 0, fmt.Errorf("paramètre scale : pourcentage positif attendu")
+		}
+		// Borné avant la conversion en int, qui déborderait
+		if percent > 100*maxOutputSide {
+			return 0, 0, fmt.Errorf("paramètre scale : %v %% trop grand (max %d %%)", percent, 100*maxOutputSide)
+		}
+		dstW, dstH := scaleSize(w, h, percent)
+		return dstW, dstH, nil
+	case req.has("width") || req.has("height"):
+		dstW, err := req.intParam("width", 0)
+		if err != nil {
+			return 0, 0, err
+		}
+		dstH, err := req.intParam("height", 0)
+		if err != nil {
+			return 0, 0, err
+		}
+		if dstW < 0 || dstH < 0 || (dstW == 0 && dstH == 0) {
+			return 0, 0, fmt.Errorf("paramètres width/height : dimensions positives attendues")
+		}
+		if dstW == 0 {
+			dstW = max(1, int(math.Round(float64(w)*float64(dstH)/float64(h))))
+		}
+		if dstH == 0 {
+			dstH = max(1, int(math.Round(float64(h)*float64(dstW)/float64(w))))
+		}
+		return dstW, dstH, nil
+	}
+	return 0, 0, fmt.Errorf("taille cible manquante (size, fit, scale, width ou height)")
+}
+
+// grayParams lit les options de conversion en gris : mode=..., weights=r,g,b
+// (mode custom), amount=P (pourcentage de désaturation, 100 par défaut) et
+// space=srgb|linear (calcul en lumière linéaire).
+func grayParams(req request) (grayOptions, error) {
+	opts := defaultGrayOptions
+	opts.Mode = req.stringParam("mode", opts.Mode)
+
+	amount, err := req.floatParam("amount", opts.Amount)
+	if err != nil {
+		return opts, err
+	}
+	opts.Amount = amount
+
+	if req.has("weights") {
+		parts := strings.Split(req.params["weights"], ",")
+		if len(parts) != 3 {
+			return opts, fmt.Errorf("paramètre weights : trois poids r,g,b attendus")
+		}
+		for i, part := range parts {
+			w, err := strconv.ParseFloat(part, 64)
+			if err != nil {
+				return opts, fmt.Errorf("paramètre weights : nombre attendu, reçu %q", part)
+			}
+			opts.Weights[i] = w
+		}
+		if !req.has("mode") {
+			opts.Mode = "custom"
+		}
+	}
+
+	if opts.Linear, err = linearParam(req); err != nil {
+		return opts, err
+	}
+
+	if _, err := grayConverter(opts); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// bilevelParams lit les options du noir et blanc 1 bit : method=..., threshold=P
+// (fixed), radius=R et offset=P (adaptive), bayer=N (2, 4 ou 8), plus les
+// options de gris de grayParams.
+func bilevelParams(req request) (bilevelOptions, error) {
+	opts := defaultBilevelOptions
+	gray, err := grayParams(req)
+	if err != nil {
+		return opts, err
+	}
+	opts.Gray = gray
+	opts.Method = req.stringParam("method", opts.Method)
+
+	if opts.Threshold, err = req.floatParam("threshold", opts.Threshold); err != nil {
+		return opts, err
+	}
+	if opts.Radius, err = req.intParam("radius", opts.Radius); err != nil {
+		return opts, err
+	}
+	if opts.Offset, err = req.floatParam("offset", opts.Offset); err != nil {
+		return opts, err
+	}
+	if opts.BayerSize, err = req.intParam("bayer", opts.BayerSize); err != nil {
+		return opts, err
+	}
+	return opts, checkBilevel(opts)
+}
+
+// quantizeParams lit les options de quantification : method=..., colors=N
+// (mediancut, octree, kmeans), levels=N (uniform), iterations=N (kmeans) et
+// dither=none|floyd-steinberg|atkinson.
+func quantizeParams(req request) (quantizeOptions, error) {
+	opts := defaultQuantizeOptions
+	opts.Method = req.stringParam("method", opts.Method)
+	opts.Dither = req.stringParam("dither", opts.Dither)
+
+	var err error
+	if opts.Colors, err = req.intParam("colors", opts.Colors); err != nil {
+		return opts, err
+	}
+	if opts.Levels, err = req.intParam("levels", opts.Levels); err != nil {
+		return opts, err
+	}
+	if opts.Iterations, err = req.intParam("iterations", opts.Iterations); err != nil {
+		return opts, err
+	}
+	return opts, checkQuantize(opts)
+}
+
+// paletteParams lit les options de projection sur une palette : palette=...
+// (nom de palette, liste #rrggbb,... ou "target" pour les colors=N couleurs du
+// median cut de l'image cible du remap), distance=rgb|redmean|cie76|ciede2000 et dither=...
+func paletteParams(req request, target *PixelBuffer) (paletteOptions, error) {
+	opts := paletteOptions{
+		Distance: req.stringParam("distance", "rgb"),
+		Dither:   req.stringParam("dither", "none"),
+	}
+	spec := req.stringParam("palette", "")
+	switch spec {
+	case "":
+		return opts, fmt.Errorf("paramètre palette manquant")
+	case "target":
+		colors, err := req.intParam("colors", 16)
+		if err != nil {
+			return opts, err
+		}
+		if colors < 1 || colors > maxPaletteColors {
+			return opts, fmt.Errorf("nombre de couleurs %d hors de [1, %d]", colors, maxPaletteColors)
+		}
+		opts.Palette = medianCutPalette(colorHistogram(target, nil), colors)
+	default:
+		palette, err := parsePalette(spec)
+		if err != nil {
+			return opts, err
+		}
+		opts.Palette = palette
+	}
+	return opts, checkPaletteMap(opts)
+}
+
+// filterParams lit les options d'un filtre spatial : filter=..., radius=R
+// (box), sigma=S (gaussian, unsharp), amount=P (sharpen, unsharp), edge=...
+// et matrix=a,b,c;d,e,f;g,h,i (noyau du filtre custom, implicite si absent).
+func filterParams(req request) (filterOptions, error) {
+	opts := defaultFilterOptions
+	opts.Filter = req.stringParam("filter", opts.Filter)
+	opts.Edge = req.stringParam("edge", opts.Edge)
+
+	var err error
+	if opts.Radius, err = req.intParam("radius", opts.Radius); err != nil {
+		return opts, err
+	}
+	if opts.Sigma, err = req.floatParam("sigma", opts.Sigma); err != nil {
+		return opts, err
+	}
+	if opts.Amount, err = req.floatParam("amount", opts.Amount); err != nil {
+		return opts, err
+	}
+	if req.has("matrix") {
+		if opts.Kernel, err = parseKernel(req.params["matrix"]); err != nil {
+			return opts, err
+		}
+		if !req.has("filter") {
+			opts.Filter = "custom"
+		}
+	}
+	_, err = filterKernel(opts)
+	return opts, err
+}
+
+// edgeParams lit les options de détection de contours : method=..., output=
+// magnitude|direction (sobel, prewitt), sigma=S (log, canny), threshold=P (log)
+// et low=P, high=P (seuils d'hystérésis de canny).
+func edgeParams(req request) (edgeOptions, error) {
+	opts := defaultEdgeOptions
+	opts.Method = req.stringParam("method", opts.Method)
+	opts.Output = req.stringParam("output", opts.Output)
+
+	var err error
+	if opts.Sigma, err = req.floatParam("sigma", opts.Sigma); err != nil {
+		return opts, err
+	}
+	if opts.Threshold, err = req.floatParam("threshold", opts.Threshold); err != nil {
+		return opts, err
+	}
+	if opts.Low, err = req.floatParam("low", opts.Low); err != nil {
+		return opts, err
+	}
+	if opts.High, err = req.floatParam("high", opts.High); err != nil {
+		return opts, err
+	}
+	return opts, checkEdgeOptions(opts)
+}
+
+// denoiseParams lit les options de débruitage : method=median|bilateral,
+// radius=R (median), sigma=S (spatial) et range=P (couleur, en %) (bilateral).
+func denoiseParams(req request) (denoiseOptions, error) {
+	opts := defaultDenoiseOptions
+	opts.Method = req.stringParam("method", opts.Method)
+
+	var err error
+	if opts.Radius, err = req.intParam("radius", opts.Radius); err != nil {
+		return opts, err
+	}
+	if opts.SigmaSpace, err = req.floatParam("sigma", opts.SigmaSpace); err != nil {
+		return opts, err
+	}
+	if opts.SigmaRange, err = req.floatParam("range", opts.SigmaRange); err != nil {
+		return opts, err
+	}
+	return opts, checkDenoise(opts)
+}
+
+// adjustParams lit les options de réglage tonal : brightness=B, contrast=C,
+// gamma=G, exposure=EV et levels=noirEntrée,blancEntrée,noirSortie,blancSortie
+// (en %, tous les canaux), que levels_r, levels_g et levels_b remplacent
+// pour un seul canal.
+func adjustParams(req request) (adjustOptions, error) {
+	opts := defaultAdjustOptions
+
+	var err error
+	if opts.Brightness, err = req.floatParam("brightness", opts.Brightness); err != nil {
+		return opts, err
+	}
+	if opts.Contrast, err = req.floatParam("contrast", opts.Contrast); err != nil {
+		return opts, err
+	}
+	if opts.Gamma, err = req.floatParam("gamma", opts.Gamma); err != nil {
+		return opts, err
+	}
+	if opts.Exposure, err = req.floatParam("exposure", opts.Exposure); err != nil {
+		return opts, err
+	}
+	for c, name := range []string{"levels_r", "levels_g", "levels_b"} {
+		for _, key := range []string{"levels", name} {
+			if !req.has(key) {
+				continue
+			}
+			if opts.Levels[c], err = levelsParam(req, key); err != nil {
+				return opts, err
+			}
+		}
+	}
+	return opts, checkAdjust(opts)
+}
+
+// equalizeParams lit les options d'égalisation : method=global|clahe,
+// tiles=XxY (grille de CLAHE) et clip=L (limite de contraste de CLAHE).
+func equalizeParams(req request) (equalizeOptions, error) {
+	opts := defaultEqualizeOptions
+	opts.Method = req.stringParam("method", opts.Method)
+
+	var err error
+	if req.has("tiles") {
+		if opts.TilesX, opts.TilesY, err = req.sizeParam("tiles"); err != nil {
+			return opts, err
+		}
+	}
+	if opts.ClipLimit, err = req.floatParam("clip", opts.ClipLimit); err != nil {
+		return opts, err
+	}
+	return opts, checkEqualize(opts)
+}
+
+// matchParams lit les options de transfert de couleurs : method=histogram|reinhard.
+func matchParams(req request) (matchOptions, error) {
+	opts := defaultMatchOptions
+	opts.Method = req.stringParam("method", opts.Method)
+	return opts, checkMatch(opts)
+}
+
+// levelsParam lit un paramètre de niveaux "noirEntrée,blancEntrée,noirSortie,blancSortie"
+func levelsParam(req request, name string) (levelsRange, error) {
+	parts := strings.Split(req.params[name], ",")
+	if len(parts) != 4 {
+		return levelsRange{}, fmt.Errorf("paramètre %s : quatre valeurs attendues (noir,blanc en entrée puis en sortie)", name)
+	}
+	var v [4]float64
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return levelsRange{}, fmt.Errorf("paramètre %s : nombre attendu, reçu %q", name, part)
+		}
+		v[i] = f
+	}
+	return levelsRange{InBlack: v[0], InWhite: v[1], OutBlack: v[2], OutWhite: v[3]}, nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"math"
+)
+
+// resampleKernel décrit un noyau d'interpolation séparable : weight(x) est le
+// poids d'un pixel source situé à une distance x (en pixels source) du point
+// échantillonné, nul au-delà de support.
+type resampleKernel struct {
+	name    string
+	support float64
+	weight  func(x float64) float64
+}
+
+// resampleKernels liste les noyaux disponibles, par nom (paramètre "kernel").
+var resampleKernels = map[string]resampleKernel{
+	"nearest": {name: "nearest", support: 0},
+	"box": {name: "box", support: 0.5, weight: func(x float64) float64 {
+		if x >= -0.5 && x < 0.5 {
+			return 1
+		}
+		return 0
+	}},
+	"bilinear": {name: "bilinear", support: 1, weight: func(x float64) float64 {
+		x = math.Abs(x)
+		if x < 1 {
+			return 1 - x
+		}
+		return 0
+	}},
+	// Catmull-Rom (cubique de Keys avec a = -0.5)
+	"bicubic": {name: "bicubic", support: 2, weight: func(x float64) float64 {
+		x = math.Abs(x)
+		switch {
+		case x < 1:
+			return (1.5*x-2.5)*x*x + 1
+		case x < 2:
+			return ((-0.5*x+2.5)*x-4)*x + 2
+		}
+		return 0
+	}},
+	// Lanczos à 3 lobes
+	"lanczos": {name: "lanczos", support: 3, weight: func(x float64) float64 {
+		x = math.Abs(x)
+		if x == 0 {
+			return 1
+		}
+		if x >= 3 {
+			return 0
+		}
+		px := math.Pi * x
+		return 3 * math.Sin(px) * math.Sin(px/3) / (px * px)
+	}},
+}
+
+// kernelByName retourne le noyau demandé ; ok vaut false si le nom est inconnu.
+func kernelByName(name string) (resampleKernel, bool) {
+	k, ok := resampleKernels[name]
+	return k, ok
+}
+
+// scaleSize retourne les dimensions (width, height) mises à l'échelle de percent %.
+func scaleSize(width, height int, percent float64) (int, int) {
+	w := int(math.Round(float64(width) * percent / 100))
+	h := int(math.Round(float64(height) * percent / 100))
+	return max(w, 1), max(h, 1)
+}
+
+// fitSize retourne les plus grandes dimensions tenant dans boxW x boxH en
+// conservant le rapport largeur/hauteur de width x height.
+func fitSize(width, height, boxW, boxH int) (int, int) {
+	if width <= 0 || height <= 0 {
+		return 0, 0
+	}
+	scale := math.Min(float64(boxW)/float64(width), float64(boxH)/float64(height))
+	w := int(math.Round(float64(width) * scale))
+	h := int(math.Round(float64(height) * scale))
+	return max(w, 1), max(h, 1)
+}
+
+// contribution regroupe les poids (normalisés) des pixels source
+// [start, start+len(weights)) pour un pixel de destination.
+type contribution struct {
+	start   int
+	weights []float32
+}
+
+// computeContributions calcule, pour chacun des dstSize pixels de destination
+// sur un axe, les pixels source qui y contribuent. En réduction, le noyau est
+// élargi du facteur d'échelle pour moyenner tous les pixels couverts (pas
+// d'aliasing) ; les bords sont gérés en répétant le pixel de bord.
+func computeContributions(srcSize, dstSize int, k resampleKernel) []contribution {
+	contribs := make([]contribution, dstSize)
+	scale := float64(srcSize) / float64(dstSize)
+
+	if k.support == 0 {
+		// Plus proche voisin : un seul pixel source de poids 1
+		for i := range contribs {
+			s := int(math.Floor((float64(i) + 0.5) * scale))
+			contribs[i] = contribution{start: min(s, srcSize-1), weights: []float32{1}}
+		}
+		return contribs
+	}
+
+	filterScale := math.Max(scale, 1)
+	support := k.support * filterScale
+	for i := range contribs {
+		center := (float64(i)+0.5)*scale - 0.5
+		lo := int(math.Floor(center - support))
+		hi := int(math.Ceil(center + support))
+
+		weights := make([]float64, 0, hi-lo+1)
+		sum := 0.0
+		for s := lo; s <= hi; s++ {
+			w := k.weight((float64(s) - center) / filterScale)
+			weights = append(weights, w)
+			sum += w
+		}
+
+		// Repli des pixels hors image sur le bord le plus proche
+		c := contribution{start: max(lo, 0)}
+		end := min(hi, srcSize-1)
+		c.weights = make([]float32, end-c.start+1)
+		for j, w := range weights {
+			s := min(max(lo+j, 0), srcSize-1)
+			c.weights[s-c.start] += float32(w / sum)
+		}
+		contribs[i] = c
+	}
+	return contribs
+}
+
+// resizeState contient les contributions des deux axes et le buffer
+// intermédiaire (résultat de la passe horizontale, en flottants).
+type resizeState struct {
+	src        *PixelBuffer
+	dst        *PixelBuffer
+	horizontal []contribution
+	vertical   []contribution
+	tmp        []float32 // 3 canaux par pixel, dst.Width x src.Height
+}
+
+// newResizeState prépare un redimensionnement de src vers dstW x dstH
+func newResizeState(src *PixelBuffer, dstW, dstH int, k resampleKernel) *resizeState {
+	return &resizeState{
+		src:        src,
+		dst:        newPixelBuffer(dstW, dstH),
+		horizontal: computeContributions(src.Width, dstW, k),
+		vertical:   computeContributions(src.Height, dstH, k),
+		tmp:        make([]float32, 3*dstW*src.Height),
+	}
+}
+
+// horizontalRows effectue la passe horizontale sur les lignes source [start, end)
+func (s *resizeState) horizontalRows(start, end int) {
+	dstW := s.dst.Width
+	for y := start; y < end; y++ {
+		in := s.src.row(y)
+		out := s.tmp[3*dstW*y : 3*dstW*(y+1)]
+		for x, c := range s.horizontal {
+			var r, g, b float32
+			for j, w := range c.weights {
+				p := in[c.start+j]
+				r += w * float32(p.R)
+				g += w * float32(p.G)
+				b += w * float32(p.B)
+			}
+			out[3*x], out[3*x+1], out[3*x+2] = r, g, b
+		}
+	}
+}
+
+// verticalRows effectue la passe verticale sur les lignes destination [start, end)
+func (s *resizeState) verticalRows(start, end int) {
+	dstW := s.dst.Width
+	for y := start; y < end; y++ {
+		c := s.vertical[y]
+		out := s.dst.row(y)
+		for x := range out {
+			var r, g, b float32
+			for j, w := range c.weights {
+				i := 3 * (dstW*(c.start+j) + x)
+				r += w * s.tmp[i]
+				g += w * s.tmp[i+1]
+				b += w * s.tmp[i+2]
+			}
+			out[x] = Pixel{R: clampChannel(r), G: clampChannel(g), B: clampChannel(b)}
+		}
+	}
+}
+
+// clampChannel arrondit et borne une valeur de canal dans [0, 65535]
+// (bicubic et Lanczos peuvent légèrement dépasser).
+func clampChannel(v float32) uint16 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 65535 {
+		return 65535
+	}
+	return uint16(v + 0.5)
+}
+
+// resizePixels redimensionne l'image en dstW x dstH avec le noyau k (séquentiel).
+// Contrairement à downscalePixels, l'image produite a réellement la nouvelle taille.
+func resizePixels(buf *PixelBuffer, dstW, dstH int, k resampleKernel) *PixelBuffer {
+	if buf.empty() || dstW <= 0 || dstH <= 0 {
+		return newPixelBuffer(max(dstW, 0), max(dstH, 0))
+	}
+	s := newResizeState(buf, dstW, dstH, k)
+	s.horizontalRows(0, buf.Height)
+	s.verticalRows(0, dstH)
+	return s.dst
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"image"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Executor répartit un travail découpé en unités (lignes, blocs, tuiles) sur
+// plusieurs goroutines. Toutes les fonctions *Parallel passent par lui au lieu
+// de refaire chacune leur découpage NumCPU/chunkSize/WaitGroup.
+type Executor struct {
+	// Workers est le nombre maximal de goroutines (0 : runtime.NumCPU()).
+	Workers int
+	// Grain est le nombre d'unités confiées à fn en un seul appel (0 : toute
+	// la bande d'un worker). Un worker reçoit toujours au moins Grain unités,
+	// et l'annulation est vérifiée entre deux morceaux.
+	Grain int
+	// Ctx permet d'interrompre le travail (nil : jamais annulé). Les morceaux
+	// déjà commencés vont jusqu'au bout ; le résultat est alors partiel.
+	Ctx context.Context
+}
+
+// parallelExec est l'exécuteur utilisé par toutes les fonctions *Parallel.
+var parallelExec = Executor{}
+
+// defaultTileSize est le côté (en pixels) des tuiles de DynamicTiles par défaut.
+const defaultTileSize = 64
+
+// numWorkers retourne le nombre de goroutines à lancer pour n unités
+func (e Executor) numWorkers(n int) int {
+	workers := e.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	// Chaque worker doit recevoir au moins Grain unités
+	if e.Grain > 1 && workers > (n+e.Grain-1)/e.Grain {
+		workers = (n + e.Grain - 1) / e.Grain
+	}
+	// Pas plus de workers que d'unités
+	if workers > n {
+		workers = n
+	}
+	return workers
+}
+
+// err retourne l'erreur du contexte, s'il y en a un
+func (e Executor) err() error {
+	if e.Ctx == nil {
+		return nil
+	}
+	return e.Ctx.Err()
+}
+
+// Rows découpe [0, n) en bandes contiguës de tailles égales (à une unité près)
+// et appelle fn(start, end) sur chacune, en parallèle. Retourne l'erreur du
+// contexte si le travail a été annulé.
+func (e Executor) Rows(n int, fn func(start, end int)) error {
+	if n <= 0 {
+		return e.err()
+	}
+	workers := e.numWorkers(n)
+	bandSize := (n + workers - 1) / workers
+
+	run := func(start, end int) {
+		step := end - start
+		if e.Grain > 0 {
+			step = e.Grain
+		}
+		for lo := start; lo < end; lo += step {
+			if e.err() != nil {
+				return
+			}
+			hi := lo + step
+			if hi > end {
+				hi = end
+			}
+			fn(lo, hi)
+		}
+	}
+
+	if workers == 1 {
+		run(0, n)
+		return e.err()
+	}
+
+	var wg sync.WaitGroup
+	for start := 0; start < n; start += bandSize {
+		end := start + bandSize
+		if end > n {
+			end = n
+		}
+		wg.Add(1)
+		go func(start, end int) {
+			defer wg.Done()
+			run(start, end)
+		}(start, end)
+	}
+	wg.Wait()
+	return e.err()
+}
+
+// runRows appelle fn sur [0, n) : en un seul appel si exec est nil
+// (version séquentielle), sinon par bandes avec exec.Rows
+func runRows(exec *Executor, n int, fn func(start, end int)) {
+	if exec == nil {
+		fn(0, n)
+	} else {
+		exec.Rows(n, fn)
+	}
+}
+
+// Tiles découpe le rectangle [0, width) x [0, height) en tuiles de
+// tileW x tileH pixels (plus petites sur les bords) et appelle fn sur chacune.
+// Les tuiles sont distribuées par bandes statiques, comme Rows.
+func (e Executor) Tiles(width, height, tileW, tileH int, fn func(r image.Rectangle)) error {
+	if tileW <= 0 {
+		tileW = width
+	}
+	if tileH <= 0 {
+		tileH = height
+	}
+	if width <= 0 || height <= 0 {
+		return e.err()
+	}
+	tilesX := (width + tileW - 1) / tileW
+	tilesY := (height + tileH - 1) / tileH
+	return e.Rows(tilesX*tilesY, func(start, end int) {
+		for t := start; t < end; t++ {
+			x0 := (t % tilesX) * tileW
+			y0 := (t / tilesX) * tileH
+			fn(image.Rect(x0, y0, x0+tileW, y0+tileH).Intersect(image.Rect(0, 0, width, height)))
+		}
+	})
+}
+
+// Queue appelle fn(i) pour chaque i de [0, n), en parallèle, avec une file de
+// travail partagée : chaque worker prend l'unité suivante (compteur atomique)
+// dès qu'il a fini la précédente. Contrairement à Rows, un worker tombé sur
+// des unités rapides ne reste pas inactif pendant que les autres finissent :
+// utile quand le coût varie d'une unité à l'autre. Avec Grain > 1, un worker
+// réserve Grain unités à la fois (moins de contention sur le compteur).
+func (e Executor) Queue(n int, fn func(i int)) error {
+	if n <= 0 {
+		return e.err()
+	}
+	grain := e.Grain
+	if grain <= 0 {
+		grain = 1
+	}
+	workers := e.numWorkers(n)
+
+	var next atomic.Int64
+	run := func() {
+		for e.err() == nil {
+			start := int(next.Add(int64(grain))) - grain
+			if start >= n {
+				return
+			}
+			end := start + grain
+			if end > n {
+				end = n
+			}
+			for i := start; i < end; i++ {
+				fn(i)
+			}
+		}
+	}
+
+	if workers == 1 {
+		run()
+		return e.err()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+			run()
+		}()
+	}
+	wg.Wait()
+	return e.err()
+}
+
+// DynamicTiles découpe l'image en tuiles comme Tiles, mais les distribue par
+// la file partagée de Queue (ordre ligne par ligne) au lieu de bandes
+// statiques. tileSize <= 0 prend defaultTileSize.
+func (e Executor) DynamicTiles(width, height, tileSize int, fn func(r image.Rectangle)) error {
+	if tileSize <= 0 {
+		tileSize = defaultTileSize
+	}
+	if width <= 0 || height <= 0 {
+		return e.err()
+	}
+	tilesX := (width + tileSize - 1) / tileSize
+	tilesY := (height + tileSize - 1) / tileSize
+	bounds := image.Rect(0, 0, width, height)
+	return e.Queue(tilesX*tilesY, func(t int) {
+		x0 := (t % tilesX) * tileSize
+		y0 := (t / tilesX) * tileSize
+		fn(image.Rect(x0, y0, x0+tileSize, y0+tileSize).Intersect(bounds))
+	})
+}
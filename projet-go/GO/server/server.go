@@ -4,8 +4,9 @@ import (
 	"bytes"
 	"fmt"
 	"image"
+	"image/gif"
 	"image/jpeg"
-	_ "image/png"
+	"image/png"
 	"io"
 	"net"
 )
@@ -32,16 +33,16 @@ func main() {
 				return
 			}
 
-			// Le premier byte est le choix, le reste est l'image
-			if len(buf) < 2 {
-				fmt.Println("Données insuffisantes")
+			// Le premier byte est le choix, puis une ligne de paramètres, puis l'image
+			req, err := parseRequest(buf)
+			if err != nil {
+				fmt.Println("Requête invalide:", err)
 				return
 			}
-			choice := buf[0]
-			imgData := buf[1:]
+			choice := req.choice
 
 			// Décoder l'image
-			img, _, err := image.Decode(bytes.NewReader(imgData))
+			img, _, err := image.Decode(bytes.NewReader(req.image))
 			if err != nil {
 				fmt.Println("Erreur décodage image:", err)
 				return
@@ -49,31 +50,184 @@ func main() {
 			b := img.Bounds()
 			w, h := b.Max.X, b.Max.Y
 
+			// Format de sortie : jpeg (défaut), png ou gif
+			format := req.stringParam("format", "jpeg")
+			if format != "jpeg" && format != "png" && format != "gif" {
+				fmt.Println("Format inconnu:", format)
+				return
+			}
+
 			// 3. Appliquer le traitement selon le choix
-			var result [][]Pixel
+			var result image.Image
 			switch choice {
 			case 1: // BW
-				fmt.Println("Traitement: Noir et blanc")
-				result = blackWhiteParallel(extractPixelsParallel(img, w, h), w, h)
+				opts, err := grayParams(req)
+				if err != nil {
+					fmt.Println("Paramètres invalides:", err)
+					return
+				}
+				fmt.Printf("Traitement: Noir et blanc (%s, %v%%, linéaire : %v)\n", opts.Mode, opts.Amount, opts.Linear)
+				result = grayscalePixelsParallel(extractPixelsParallel(img, w, h), opts)
 			case 2: // Downscale
-				fmt.Println("Traitement: Downscale (facteur 4)")
+				factor, linear, err := downscaleParams(req)
+				if err != nil {
+					fmt.Println("Paramètres invalides:", err)
+					return
+				}
 				pixels := extractPixelsParallel(img, w, h)
-				result = downscalePixelsParallel(pixels, w, h, 4)
+				if linear {
+					fmt.Printf("Traitement: Downscale (facteur %d, lumière linéaire)\n", factor)
+					result = downscalePixelsLinearParallel(pixels, factor)
+				} else {
+					fmt.Printf("Traitement: Downscale (facteur %d)\n", factor)
+					result = downscalePixelsParallel(pixels, factor)
+				}
 			case 3: // Remap
-				fmt.Println("Traitement: Remap vers carosse_500x500.jpg")
+				opts, err := remapParams(req)
+				if err != nil {
+					fmt.Println("Paramètres invalides:", err)
+					return
+				}
+				if opts.Method == "sliced" {
+					fmt.Printf("Traitement: Remap vers carosse_500x500.jpg (transport sliced, %d itérations, graine %d)\n", opts.Iterations, opts.Seed)
+				} else {
+					fmt.Printf("Traitement: Remap vers carosse_500x500.jpg (%d niveaux, distance %s, graine %d)\n", opts.Levels, opts.Distance, opts.Seed)
+				}
+				if opts.RefinePasses > 0 {
+					fmt.Printf("Affinage : %d passes, flou de la cible %v\n", opts.RefinePasses, opts.RefineSigma)
+				}
 				if w != targetW || h != targetH {
 					fmt.Printf("Dimensions incompatibles (%dx%d vs %dx%d)\n", w, h, targetW, targetH)
 					return
 				}
 				srcMatrix := extractPixelsParallel(img, w, h)
-				result = remapPixelsParallel(srcMatrix, targetMatrix, 16)
+				result = remapPixelsParallel(srcMatrix, targetMatrix, opts)
+			case 4: // Resize
+				dstW, dstH, err := resizeParams(req, w, h)
+				if err != nil {
+					fmt.Println("Paramètres invalides:", err)
+					return
+				}
+				k, ok := kernelByName(req.stringParam("kernel", "lanczos"))
+				if !ok {
+					fmt.Println("Noyau inconnu:", req.stringParam("kernel", ""))
+					return
+				}
+				fmt.Printf("Traitement: Resize %dx%d -> %dx%d (%s)\n", w, h, dstW, dstH, k.name)
+				result = resizePixelsParallel(extractPixelsParallel(img, w, h), dstW, dstH, k)
+			case 5: // Upscale
+				factor, err := req.intParam("factor", 2)
+				if err != nil {
+					fmt.Println("Paramètres invalides:", err)
+					return
+				}
+				method := req.stringParam("method", "nearest")
+				if err := checkUpscale(method, factor); err != nil {
+					fmt.Println("Paramètres invalides:", err)
+					return
+				}
+				// Comparé avant de multiplier : factor*w peut déborder
+				if w < 1 || h < 1 || factor > maxOutputSide/w || factor > maxOutputSide/h {
+					fmt.Printf("Facteur %d trop grand pour %dx%d (max %d de côté)\n", factor, w, h, maxOutputSide)
+					return
+				}
+				if factor*w*factor*h > maxOutputPixels {
+					fmt.Printf("Facteur %d trop grand pour %dx%d (max %d pixels)\n", factor, w, h, maxOutputPixels)
+					return
+				}
+				fmt.Printf("Traitement: Upscale x%d (%s)\n", factor, method)
+				result = upscalePixelsParallel(extractPixelsParallel(img, w, h), factor, method)
+			case 6: // Noir et blanc 1 bit
+				opts, err := bilevelParams(req)
+				if err != nil {
+					fmt.Println("Paramètres invalides:", err)
+					return
+				}
+				fmt.Printf("Traitement: Noir et blanc 1 bit (%s)\n", opts.Method)
+				bw := binarizePixelsParallel(extractPixelsParallel(img, w, h), opts)
+				if format == "jpeg" {
+					result = bw
+				} else {
+					result = bilevelImage(bw) // 1 bit par pixel en PNG/GIF
+				}
+			case 7: // Quantification (palette)
+				opts, err := quantizeParams(req)
+				if err != nil {
+					fmt.Println("Paramètres invalides:", err)
+					return
+				}
+				fmt.Printf("Traitement: Quantification (%s, tramage %s)\n", opts.Method, opts.Dither)
+				result = quantizeImageParallel(extractPixelsParallel(img, w, h), opts)
+			case 8: // Projection sur une palette imposée
+				opts, err := paletteParams(req, targetMatrix)
+				if err != nil {
+					fmt.Println("Paramètres invalides:", err)
+					return
+				}
+				fmt.Printf("Traitement: Palette imposée (%d couleurs, %s, tramage %s)\n", len(opts.Palette), opts.Distance, opts.Dither)
+				result = paletteMapImageParallel(extractPixelsParallel(img, w, h), opts)
+			case 9: // Filtre (convolution)
+				opts, err := filterParams(req)
+				if err != nil {
+					fmt.Println("Paramètres invalides:", err)
+					return
+				}
+				fmt.Printf("Traitement: Filtre %s (bords %s)\n", opts.Filter, opts.Edge)
+				result = filterPixelsParallel(extractPixelsParallel(img, w, h), opts)
+			case 10: // Contours
+				opts, err := edgeParams(req)
+				if err != nil {
+					fmt.Println("Paramètres invalides:", err)
+					return
+				}
+				fmt.Printf("Traitement: Contours %s\n", opts.Method)
+				result = edgePixelsParallel(extractPixelsParallel(img, w, h), opts)
+			case 11: // Débruitage
+				opts, err := denoiseParams(req)
+				if err != nil {
+					fmt.Println("Paramètres invalides:", err)
+					return
+				}
+				fmt.Printf("Traitement: Débruitage %s\n", opts.Method)
+				result = denoisePixelsParallel(extractPixelsParallel(img, w, h), opts)
+			case 12: // Réglages tonals
+				opts, err := adjustParams(req)
+				if err != nil {
+					fmt.Println("Paramètres invalides:", err)
+					return
+				}
+				fmt.Println("Traitement: Réglages (luminosité, contraste, gamma, exposition, niveaux)")
+				result = adjustPixelsParallel(extractPixelsParallel(img, w, h), opts)
+			case 13: // Égalisation d'histogramme
+				opts, err := equalizeParams(req)
+				if err != nil {
+					fmt.Println("Paramètres invalides:", err)
+					return
+				}
+				fmt.Println("Traitement: Égalisation d'histogramme (" + opts.Method + ")")
+				result = equalizePixelsParallel(extractPixelsParallel(img, w, h), opts)
+			case 14: // Transfert de couleurs
+				opts, err := matchParams(req)
+				if err != nil {
+					fmt.Println("Paramètres invalides:", err)
+					return
+				}
+				fmt.Println("Traitement: Transfert des couleurs de carosse_500x500.jpg (" + opts.Method + ")")
+				result = matchPixelsParallel(extractPixelsParallel(img, w, h), targetMatrix, opts)
 			default:
 				fmt.Println("Choix invalide:", choice)
 				return
 			}
 
 			// 4. Encoder et envoyer le résultat
-			jpeg.Encode(c, pixelsToImage(result), nil)
+			switch format {
+			case "png":
+				png.Encode(c, result)
+			case "gif":
+				gif.Encode(c, result, nil) // les images palettisées gardent leur palette
+			default:
+				jpeg.Encode(c, result, nil)
+			}
 			fmt.Println("Requête complétée")
 		}(conn)
 	}
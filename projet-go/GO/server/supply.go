@@ -0,0 +1,256 @@
+package main
+
+import (
+	"cmp"
+	"container/heap"
+	"math"
+	"slices"
+)
+
+// binSupply est la réserve de pixels sources du remap, rangés par bin de
+// couleur. Quand le bin demandé est épuisé, le bin non vide le plus proche est
+// cherché dans un k-d tree des bins fournis au lieu d'un parcours des levels³
+// bins : les sous-arbres vides ou trop éloignés sont ignorés, et un bin épuisé
+// est retiré des compteurs de ses ancêtres en O(log n).
+//
+// Les bins non vides ne font que disparaître : tant que le dernier résultat
+// pour un bin est encore non vide, il reste le plus proche et la recherche
+// est évitée (cache).
+type binSupply struct {
+	bins    [][]Pixel
+	centers [][3]float64 // centre de chaque bin, dans l'espace de la distance
+	dist    func(a, b [3]float64) float64
+	bound   func(q, lo, hi [3]float64) float64
+
+	// k-d tree implicite sur order : le sous-arbre qui couvre order[lo:hi] a
+	// pour pivot order[mid], mid = (lo+hi)/2, ses fils couvrent order[lo:mid]
+	// et order[mid+1:hi]. Les tableaux suivants sont indexés par mid.
+	order  []int32
+	axis   []uint8
+	live   []int32      // nombre de bins non vides du sous-arbre
+	lo, hi [][3]float64 // boîte englobante des centres du sous-arbre
+	pos    []int32      // position de chaque bin dans order (-1 si non fourni)
+	cache  []int32      // dernier bin le plus proche trouvé pour chaque bin (-1 : aucun)
+}
+
+// newBinSupply range les pixels de src par bin (levels niveaux par canal) et
+// indexe les bins fournis selon distance (clé de colorMetrics)
+func newBinSupply(src *PixelBuffer, levels int, distance string) *binSupply {
+	metric := colorMetrics[distance]
+	s := &binSupply{
+		bins:    buildSourceBins(src, levels),
+		centers: make([][3]float64, levels*levels*levels),
+		dist:    metric.dist,
+		bound:   metric.bound,
+	}
+	for i := range s.centers {
+		r, g, b := binCenter(i, levels)
+		s.centers[i] = metric.coords(Pixel{R: uint16(r), G: uint16(g), B: uint16(b)})
+	}
+
+	for bin, supply := range s.bins {
+		if len(supply) > 0 {
+			s.order = append(s.order, int32(bin))
+		}
+	}
+	n := len(s.order)
+	s.axis = make([]uint8, n)
+	s.live = make([]int32, n)
+	s.lo = make([][3]float64, n)
+	s.hi = make([][3]float64, n)
+	s.build(0, n)
+	s.pos = make([]int32, len(s.bins))
+	s.cache = make([]int32, len(s.bins))
+	for i := range s.pos {
+		s.pos[i], s.cache[i] = -1, -1
+	}
+	for i, bin := range s.order {
+		s.pos[bin] = int32(i)
+	}
+	return s
+}
+
+// build construit le sous-arbre qui couvre order[lo:hi] : coupe sur l'axe où
+// les centres sont le plus étalés, à la médiane
+func (s *binSupply) build(lo, hi int) {
+	if lo >= hi {
+		return
+	}
+	mid := (lo + hi) / 2
+	boxLo, boxHi := s.centers[s.order[lo]], s.centers[s.order[lo]]
+	for _, bin := range s.order[lo+1 : hi] {
+		c := s.centers[bin]
+		for a := range c {
+			boxLo[a], boxHi[a] = min(boxLo[a], c[a]), max(boxHi[a], c[a])
+		}
+	}
+	axis := 0
+	for a := 1; a < 3; a++ {
+		if boxHi[a]-boxLo[a] > boxHi[axis]-boxLo[axis] {
+			axis = a
+		}
+	}
+	slices.SortFunc(s.order[lo:hi], func(a, b int32) int {
+		return cmp.Or(cmp.Compare(s.centers[a][axis], s.centers[b][axis]), cmp.Compare(a, b))
+	})
+	s.axis[mid], s.live[mid] = uint8(axis), int32(hi-lo)
+	s.lo[mid], s.hi[mid] = boxLo, boxHi
+	s.build(lo, mid)
+	s.build(mid+1, hi)
+}
+
+// nearest retourne bin s'il a encore des pixels, sinon le bin non vide dont le
+// centre est le plus proche (à distance égale, le plus petit index, comme un
+// parcours de tous les bins) ; -1 si tous les bins sont vides.
+func (s *binSupply) nearest(bin int) int {
+	if len(s.bins[bin]) > 0 {
+		return bin
+	}
+	if c := s.cache[bin]; c >= 0 && len(s.bins[c]) > 0 {
+		return int(c)
+	}
+	q := s.centers[bin]
+	best, bestDist := int32(-1), math.Inf(1)
+
+	var search func(lo, hi int)
+	search = func(lo, hi int) {
+		if lo >= hi {
+			return
+		}
+		mid := (lo + hi) / 2
+		// Strictement plus loin que le meilleur : rien à gagner, même à égalité
+		if s.live[mid] == 0 || s.bound(q, s.lo[mid], s.hi[mid]) > bestDist {
+			return
+		}
+		pivot := s.order[mid]
+		if len(s.bins[pivot]) > 0 {
+			if d := s.dist(q, s.centers[pivot]); d < bestDist || (d == bestDist && pivot < best) {
+				best, bestDist = pivot, d
+			}
+		}
+		// Le côté de q d'abord : le meilleur candidat y est le plus probable
+		if axis := s.axis[mid]; q[axis] < s.centers[pivot][axis] {
+			search(lo, mid)
+			search(mid+1, hi)
+		} else {
+			search(mid+1, hi)
+			search(lo, mid)
+		}
+	}
+	search(0, len(s.order))
+	s.cache[bin] = best
+	return int(best)
+}
+
+// pop enlève et retourne un pixel du bin demandé, ou du bin non vide le plus
+// proche s'il est épuisé. Retourne false si la réserve est vide (ne devrait
+// pas arriver si les images ont le même nombre de pixels).
+func (s *binSupply) pop(bin int) (Pixel, bool) {
+	taken := s.take(bin, 1)
+	if len(taken) == 0 {
+		return Pixel{}, false
+	}
+	return taken[0], true
+}
+
+// take enlève et retourne jusqu'à n pixels du bin demandé, ou du bin non vide
+// le plus proche s'il est épuisé (moins de n si ce bin en a moins) ; nil si la
+// réserve est vide.
+func (s *binSupply) take(bin, n int) []Pixel {
+	best := s.nearest(bin)
+	if best == -1 {
+		return nil
+	}
+
+	supply := s.bins[best]
+	k := min(n, len(supply))
+	taken := supply[len(supply)-k:]
+	s.bins[best] = supply[:len(supply)-k]
+	if k == len(supply) {
+		s.remove(s.pos[best])
+	}
+	return taken
+}
+
+// plan répartit la réserve entre les bins selon demand (nombre de pixels
+// voulus par bin) et retourne les pixels attribués à chaque bin. Chaque bin
+// est d'abord servi par ses propres pixels ; les déficits restants sont
+// ensuite servis du plus proche au plus lointain : à chaque étape, le bin en
+// déficit dont le bin non vide le plus proche est le moins éloigné y prend ce
+// qu'il lui manque (ou tout ce qui reste). Un bin reçoit moins que sa demande
+// seulement si la réserve est épuisée.
+func (s *binSupply) plan(demand []int) [][]Pixel {
+	plan := make([][]Pixel, len(demand))
+	for bin, n := range demand {
+		if n > 0 && len(s.bins[bin]) > 0 {
+			plan[bin] = s.take(bin, n)
+		}
+	}
+
+	var deficits deficitHeap
+	push := func(bin int) {
+		if best := s.nearest(bin); best >= 0 {
+			heap.Push(&deficits, deficit{bin: int32(bin), source: int32(best), dist: s.dist(s.centers[bin], s.centers[best])})
+		}
+	}
+	for bin, n := range demand {
+		if len(plan[bin]) < n {
+			push(bin)
+		}
+	}
+	for deficits.Len() > 0 {
+		d := heap.Pop(&deficits).(deficit)
+		bin := int(d.bin)
+		if len(s.bins[d.source]) == 0 {
+			// Source épuisée entre-temps : le bin repart avec la suivante
+			push(bin)
+			continue
+		}
+		plan[bin] = append(plan[bin], s.take(int(d.source), demand[bin]-len(plan[bin]))...)
+		if len(plan[bin]) < demand[bin] {
+			push(bin)
+		}
+	}
+	return plan
+}
+
+// deficit est un bin encore en déficit, avec le bin non vide le plus proche
+// au moment où il a été rangé dans le tas
+type deficit struct {
+	bin, source int32
+	dist        float64
+}
+
+// deficitHeap range les déficits par distance croissante (à distance égale,
+// par index de bin croissant)
+type deficitHeap []deficit
+
+func (h deficitHeap) Len() int { return len(h) }
+func (h deficitHeap) Less(i, j int) bool {
+	return h[i].dist < h[j].dist || (h[i].dist == h[j].dist && h[i].bin < h[j].bin)
+}
+func (h deficitHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *deficitHeap) Push(x any)   { *h = append(*h, x.(deficit)) }
+func (h *deficitHeap) Pop() any {
+	old := *h
+	d := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return d
+}
+
+// remove retire le bin en position i de order des compteurs de ses ancêtres
+func (s *binSupply) remove(i int32) {
+	lo, hi := int32(0), int32(len(s.order))
+	for {
+		mid := (lo + hi) / 2
+		s.live[mid]--
+		switch {
+		case i == mid:
+			return
+		case i < mid:
+			hi = mid
+		default:
+			lo = mid + 1
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"slices"
+)
+
+// hilbertBits est le nombre de bits par canal de la courbe de Hilbert du
+// remap "sliced" : la pleine précision des pixels (clés de 48 bits).
+const hilbertBits = 16
+
+// maxSlicedIterations limite le nombre d'itérations du remap "sliced"
+const maxSlicedIterations = 200
+
+// checkSliced retourne une erreur si le nombre d'itérations est invalide
+func checkSliced(iterations int) error {
+	if iterations < 0 || iterations > maxSlicedIterations {
+		return fmt.Errorf("itérations %d hors de [0, %d]", iterations, maxSlicedIterations)
+	}
+	return nil
+}
+
+// hilbertIndex retourne la position du point x (bits bits par coordonnée)
+// sur la courbe de Hilbert qui parcourt le cube [0, 2^bits)³ : deux points
+// consécutifs sur la courbe sont voisins dans le cube. Algorithme de
+// Skilling (2004) : coordonnées -> forme "transposée" de l'index, puis
+// entrelacement des bits.
+func hilbertIndex(x [3]uint32, bits int) uint64 {
+	top := uint32(1) << (bits - 1)
+	for q := top; q > 1; q >>= 1 {
+		mask := q - 1
+		for i := range x {
+			if x[i]&q != 0 {
+				x[0] ^= mask
+			} else {
+				t := (x[0] ^ x[i]) & mask
+				x[0] ^= t
+				x[i] ^= t
+			}
+		}
+	}
+	// Code de Gray
+	for i := 1; i < len(x); i++ {
+		x[i] ^= x[i-1]
+	}
+	t := uint32(0)
+	for q := top; q > 1; q >>= 1 {
+		if x[len(x)-1]&q != 0 {
+			t ^= q - 1
+		}
+	}
+	for i := range x {
+		x[i] ^= t
+	}
+
+	var index uint64
+	for b := bits - 1; b >= 0; b-- {
+		for i := range x {
+			index = index<<1 | uint64(x[i]>>b&1)
+		}
+	}
+	return index
+}
+
+// hilbertKey retourne la position de p sur la courbe de Hilbert du cube RGB
+func hilbertKey(p Pixel) uint64 {
+	return hilbertIndex([3]uint32{uint32(p.R), uint32(p.G), uint32(p.B)}, hilbertBits)
+}
+
+// projection est la projection d'un point sur une direction, avec son index
+type projection struct {
+	v float32
+	i int32
+}
+
+// transportRemap est le remap "sliced" : une approximation du transport
+// optimal entre les couleurs de la source et celles de la cible, qui
+// minimise l'écart total sans bins.
+//
+// Sur une droite, le transport optimal apparie simplement les rangs. À
+// chaque itération, une copie des couleurs sources est projetée sur trois
+// directions orthogonales tirées au hasard, et chaque point est déplacé, le
+// long de chaque direction, vers la valeur de la cible de même rang : la
+// copie converge vers la distribution de la cible (sliced optimal transport).
+// Il reste à apparier deux nuages presque identiques : les points déplacés et
+// les couleurs de la cible sont triés sur une courbe de Hilbert du cube RGB,
+// et le k-ième pixel source va à la k-ième position. Avec 0 itération, c'est
+// directement un transport le long de la courbe.
+//
+// Les directions et l'ordre des positions de même couleur (pour ne pas créer
+// de dégradés dans les aplats) sont tirés de opts.Seed. Les projections, les
+// tris d'une itération et le placement sont répartis par exec ; chaque point
+// subit les mêmes opérations dans le même ordre : le résultat ne dépend pas
+// de exec.
+func transportRemap(src, target *PixelBuffer, opts remapOptions, exec *Executor) *PixelBuffer {
+	width, height := target.Width, target.Height
+	n := width * height
+
+	moved := make([][3]float32, n)
+	colors := make([][3]float32, n)
+	runRows(exec, height, func(start, end int) {
+		for y := start; y < end; y++ {
+			for x, p := range src.row(y) {
+				moved[y*width+x] = [3]float32{float32(p.R), float32(p.G), float32(p.B)}
+			}
+			for x, t := range target.row(y) {
+				colors[y*width+x] = [3]float32{float32(t.R), float32(t.G), float32(t.B)}
+			}
+		}
+	})
+
+	rng := rand.New(rand.NewPCG(uint64(opts.Seed), 0))
+	var sorted [3][]projection  // projections des points déplacés, triées
+	var ranked [3][]projection  // projections de la cible, triées
+	var scratch [6][]projection // tampon de chaque tâche de tri
+	for d := range sorted {
+		sorted[d] = make([]projection, n)
+		ranked[d] = make([]projection, n)
+	}
+	for task := range scratch {
+		scratch[task] = make([]projection, n)
+	}
+	for range opts.Iterations {
+		basis := randomBasis(rng)
+		// Tâche 2d : points déplacés sur la direction d ; 2d+1 : cible
+		runRows(exec, 2*len(basis), func(start, end int) {
+			for task := start; task < end; task++ {
+				d, axis := task/2, basis[task/2]
+				if task%2 == 0 {
+					for i, c := range moved {
+						sorted[d][i] = projection{v: dot3(c, axis), i: int32(i)}
+					}
+					radixSort(sorted[d], scratch[task])
+				} else {
+					for i, c := range colors {
+						ranked[d][i] = projection{v: dot3(c, axis), i: int32(i)}
+					}
+					radixSort(ranked[d], scratch[task])
+				}
+			}
+		})
+		// Les rangs sont tous calculés avant de déplacer : les trois pas sont
+		// appliqués dans l'ordre des directions, à des points distincts par rang
+		for d, axis := range basis {
+			runRows(exec, n, func(start, end int) {
+				for k := start; k < end; k++ {
+					p := sorted[d][k]
+					step := ranked[d][k].v - p.v
+					for c := range axis {
+						moved[p.i][c] += step * axis[c]
+					}
+				}
+			})
+		}
+	}
+
+	// Appariement sur la courbe de Hilbert ; les points déplacés sont ramenés
+	// dans le cube RGB, les positions de même couleur départagées au hasard
+	type item struct {
+		key uint64
+		tie int32
+		i   int32
+	}
+	sources := make([]item, n)
+	targets := make([]item, n)
+	ties := rand.New(rand.NewPCG(uint64(opts.Seed), 1)).Perm(n)
+	runRows(exec, n, func(start, end int) {
+		for i := start; i < end; i++ {
+			c := moved[i]
+			sources[i] = item{key: hilbertKey(Pixel{R: clampChannel(c[0]), G: clampChannel(c[1]), B: clampChannel(c[2])}), i: int32(i)}
+			targets[i] = item{key: hilbertKey(target.Pix[target.offset(i%width, i/width)]), tie: int32(ties[i]), i: int32(i)}
+		}
+	})
+	byKey := func(a, b item) int {
+		return cmp.Or(cmp.Compare(a.key, b.key), cmp.Compare(a.tie, b.tie), cmp.Compare(a.i, b.i))
+	}
+	runRows(exec, 2, func(start, end int) {
+		for _, items := range [][]item{sources, targets}[start:end] {
+			slices.SortFunc(items, byKey)
+		}
+	})
+
+	out := newPixelBuffer(width, height)
+	runRows(exec, n, func(start, end int) {
+		for k := start; k < end; k++ {
+			s, t := int(sources[k].i), int(targets[k].i)
+			out.Pix[out.offset(t%width, t/width)] = src.Pix[src.offset(s%width, s/width)]
+		}
+	})
+	return out
+}
+
+// radixSort trie p par valeur croissante (tri par base, stable : à valeur
+// égale, l'ordre d'entrée est conservé) ; tmp est un tampon de même taille.
+func radixSort(p, tmp []projection) {
+	// Clé entière dans le même ordre que les flottants : on inverse tous les
+	// bits des négatifs, seulement le bit de signe des positifs
+	key := func(v float32) uint32 {
+		bits := math.Float32bits(v)
+		if bits>>31 != 0 {
+			return ^bits
+		}
+		return bits | 1<<31
+	}
+	src, dst := p, tmp
+	for shift := 0; shift < 32; shift += 16 {
+		var count [1 << 16]int
+		for _, x := range src {
+			count[key(x.v)>>shift&0xffff]++
+		}
+		pos := 0
+		for b, c := range count {
+			count[b] = pos
+			pos += c
+		}
+		for _, x := range src {
+			b := key(x.v) >> shift & 0xffff
+			dst[count[b]] = x
+			count[b]++
+		}
+		src, dst = dst, src
+	}
+	// Nombre pair de passes : le résultat est de nouveau dans p
+}
+
+// randomBasis tire une base orthonormée de l'espace RGB (Gram-Schmidt sur
+// des vecteurs gaussiens, donc uniformément orientée)
+func randomBasis(rng *rand.Rand) [3][3]float32 {
+	var basis [3][3]float64
+	for b := 0; b < len(basis); {
+		v := [3]float64{rng.NormFloat64(), rng.NormFloat64(), rng.NormFloat64()}
+		for _, u := range basis[:b] {
+			d := v[0]*u[0] + v[1]*u[1] + v[2]*u[2]
+			for c := range v {
+				v[c] -= d * u[c]
+			}
+		}
+		norm := math.Sqrt(v[0]*v[0] + v[1]*v[1] + v[2]*v[2])
+		if norm < 1e-6 {
+			continue // vecteur presque dans le plan des précédents : on retire
+		}
+		for c := range v {
+			basis[b][c] = v[c] / norm
+		}
+		b++
+	}
+	var out [3][3]float32
+	for b := range basis {
+		for c := range basis[b] {
+			out[b][c] = float32(basis[b][c])
+		}
+	}
+	return out
+}
+
+// dot3 retourne le produit scalaire de a et b
+func dot3(a, b [3]float32) float32 {
+	return a[0]*b[0] + a[1]*b[1] + a[2]*b[2]
+}